@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"path"
+	"time"
+)
+
+// response writer recording status, size and errors for the access log
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	status   int
+	bytes    int64
+	err      error
+	canceled bool
+}
+
+func (lw *loggingResponseWriter) WriteHeader(status int) {
+	if lw.status == 0 {
+		lw.status = status
+	}
+	lw.ResponseWriter.WriteHeader(status)
+}
+
+func (lw *loggingResponseWriter) Write(b []byte) (n int, err error) {
+	if lw.status == 0 {
+		lw.status = http.StatusOK
+	}
+	n, err = lw.ResponseWriter.Write(b)
+	lw.bytes += int64(n)
+	return
+}
+
+// underlying response writer for http.ResponseController
+func (lw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lw.ResponseWriter
+}
+
+// access log entry in json format
+type accessEntry struct {
+	Time     time.Time `json:"time"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	Status   int       `json:"status"`
+	Bytes    int64     `json:"bytes"`
+	Duration float64   `json:"duration"` // seconds
+	RemoteIP string    `json:"remote_ip"`
+	Error    string    `json:"error,omitempty"`
+	Canceled bool      `json:"canceled,omitempty"`
+}
+
+// logging writer below any response writers wrapping it, nil outside of accessLog
+func loggingWriter(w http.ResponseWriter) *loggingResponseWriter {
+
+	for {
+		switch writer := w.(type) {
+		case *loggingResponseWriter:
+			return writer
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = writer.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// log errors of a request
+// within the json access log they become part of the request's entry,
+// without an entry to carry them they are printed
+func (s *server) logError(w http.ResponseWriter, err error) {
+
+	lw := loggingWriter(w)
+	if lw != nil {
+		lw.err = err
+	}
+
+	if s.conf().LogFormat != "json" || lw == nil {
+		s.Logger.Println(err)
+	}
+}
+
+// status logged for requests the client gave up on before a response was sent
+const statusClientClosed = 499
+
+// log requests the client gave up on apart from errors
+// the request's context is canceled once the client is gone
+func (s *server) logCanceled(w http.ResponseWriter, r *http.Request) {
+
+	lw := loggingWriter(w)
+	if lw != nil {
+		lw.canceled = true
+	}
+
+	if s.conf().LogFormat != "json" || lw == nil {
+		s.info("canceled by client:", r.Method, r.URL.Path)
+	}
+}
+
+// whether a successful request is left out of the access log
+// by the exclusion patterns or by sampling, failed ones are always logged
+func skipAccessLog(conf config, r *http.Request, lw *loggingResponseWriter) bool {
+
+	if lw.status >= 400 || lw.err != nil || lw.canceled {
+		return false
+	}
+
+	for _, pattern := range conf.LogExcludePaths {
+		if matched, _ := path.Match(pattern, r.URL.Path); matched {
+			return true
+		}
+	}
+
+	return conf.LogSampleRate > 0 && conf.LogSampleRate < 1 && rand.Float64() >= conf.LogSampleRate
+}
+
+// wrap a handler to record metrics and write an access log entry per request
+// in text or json format
+func (s *server) accessLog(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		start := time.Now()
+		lw := &loggingResponseWriter{ResponseWriter: w}
+		s.Runtime.inFlight.Add(1)
+		next(lw, r)
+		s.Runtime.inFlight.Add(-1)
+
+		if lw.status == 0 && lw.canceled {
+			lw.status = statusClientClosed
+		} else if lw.status == 0 {
+			lw.status = http.StatusOK
+		}
+		s.Metrics.observeRequest(lw.status, lw.bytes, time.Since(start))
+		s.Runtime.observe(lw.status, lw.bytes)
+
+		if skipAccessLog(s.conf(), r, lw) {
+			return
+		}
+
+		// one line per request in text format, only logged at info level
+		if s.conf().LogFormat != "json" {
+			s.info(fmt.Sprintf("%s %s %d %d %s %s", r.Method, r.URL.Path, lw.status, lw.bytes, time.Since(start), s.clientIP(r)))
+			return
+		}
+
+		// entries of failed requests carry their errors, so they are kept at every level
+		if s.conf().logLevel() > levelInfo && lw.err == nil && lw.status < 500 {
+			return
+		}
+
+		entry := accessEntry{
+			Time:     start,
+			Method:   r.Method,
+			Path:     r.URL.Path,
+			Status:   lw.status,
+			Bytes:    lw.bytes,
+			Duration: time.Since(start).Seconds(),
+			RemoteIP: s.clientIP(r),
+			Canceled: lw.canceled,
+		}
+		if lw.err != nil {
+			entry.Error = lw.err.Error()
+		}
+
+		line, err := json.Marshal(entry)
+		if err != nil {
+			s.Logger.Println(err)
+			return
+		}
+		s.Logger.Writer().Write(append(line, '\n'))
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestJSONAccessLogKeepsErrors(t *testing.T) {
+
+	for _, level := range []string{"info", "warn", "error"} {
+		conf := testConfig()
+		conf.LogFormat = "json"
+		conf.LogLevel = level
+		s, gfs := newTestServer(t, conf)
+		var logged bytes.Buffer
+		s.Logger = log.New(&logged, "", 0)
+		gfs.put("a.txt", "hello", "text/plain", nil)
+		gfs.put("broken.txt", "hello world", "text/plain", nil).failAfter = 2
+
+		serve(s, httptest.NewRequest("GET", "/gridfs/a.txt", nil))
+		serve(s, httptest.NewRequest("GET", "/gridfs/broken.txt", nil))
+
+		var entries []accessEntry
+		for _, line := range strings.Split(strings.TrimSpace(logged.String()), "\n") {
+			var entry accessEntry
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("%s: %q: %s", level, line, err)
+			}
+			entries = append(entries, entry)
+		}
+
+		// the successful request is only logged at info level
+		want := 1
+		if level == "info" {
+			want = 2
+		}
+		if len(entries) != want {
+			t.Fatalf("%s: got %d entries", level, len(entries))
+		}
+		if failed := entries[len(entries)-1]; failed.Path != "/gridfs/broken.txt" || failed.Error == "" {
+			t.Errorf("%s: got %+v", level, failed)
+		}
+	}
+}
+
+func TestLogErrorWithoutAccessLog(t *testing.T) {
+
+	conf := testConfig()
+	conf.LogFormat = "json"
+	s, _ := newTestServer(t, conf)
+	var logged bytes.Buffer
+	s.Logger = log.New(&logged, "", 0)
+
+	s.logError(httptest.NewRecorder(), errors.New("lost otherwise"))
+	if !strings.Contains(logged.String(), "lost otherwise") {
+		t.Errorf("got %q", logged.String())
+	}
+
+	// wrapped writers still hand errors to the entry
+	lw := &loggingResponseWriter{ResponseWriter: httptest.NewRecorder()}
+	logged.Reset()
+	s.logError(unwrapper{lw}, errors.New("in the entry"))
+	if lw.err == nil || logged.Len() != 0 {
+		t.Errorf("got %v %q", lw.err, logged.String())
+	}
+}
+
+// response writer wrapping another one
+type unwrapper struct {
+	http.ResponseWriter
+}
+
+func (u unwrapper) Unwrap() http.ResponseWriter {
+	return u.ResponseWriter
+}
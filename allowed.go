@@ -0,0 +1,41 @@
+package main
+
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// check whether the mount may serve a file of the given name and content type
+// empty lists allow everything, types may be given as "type/*"
+func (m *mount) allowed(name string, ctype string) bool {
+
+	if len(m.AllowedExtensions) > 0 {
+		ext := strings.ToLower(filepath.Ext(name))
+		found := false
+		for _, allowed := range m.AllowedExtensions {
+			if strings.ToLower(allowed) == ext {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(m.AllowedTypes) > 0 {
+		mediatype, _, err := mime.ParseMediaType(ctype)
+		if err != nil {
+			return false
+		}
+		for _, allowed := range m.AllowedTypes {
+			if allowed == mediatype || strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediatype, allowed[:len(allowed)-1]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAllowedFiles(t *testing.T) {
+
+	conf := testConfig()
+	conf.AllowedExtensions = []string{".png", ".TXT"}
+	conf.AllowedTypes = []string{"image/*", "text/plain"}
+	s, gfs := newTestServer(t, conf)
+	gfs.put("a.png", "png", "image/png", nil)
+	gfs.put("b.txt", "text", "text/plain; charset=utf-8", nil)
+	gfs.put("c.exe", "MZ", "application/octet-stream", nil)
+	gfs.put("d.png", "<html></html>", "text/html", nil)
+	gfs.put("e.txt", "\x89PNG\r\n\x1a\n", "", nil)
+
+	for path, want := range map[string]int{
+		"/gridfs/a.png": http.StatusOK,
+		"/gridfs/b.txt": http.StatusOK,
+		"/gridfs/c.exe": http.StatusForbidden,
+		"/gridfs/d.png": http.StatusForbidden,
+		"/gridfs/e.txt": http.StatusOK,
+	} {
+		if w := serve(s, httptest.NewRequest("GET", path, nil)); w.Code != want {
+			t.Errorf("%s: got %d, want %d", path, w.Code, want)
+		}
+	}
+}
+
+func TestDeniedFilesRevealNothing(t *testing.T) {
+
+	conf := testConfig()
+	conf.AllowedTypes = []string{"image/*"}
+	conf.EnableImageResize = true
+	s, gfs := newTestServer(t, conf)
+	file := gfs.put("a.exe", "MZ", "application/octet-stream", nil)
+
+	// a matching validator is no way around the list
+	r := httptest.NewRequest("GET", "/gridfs/a.exe", nil)
+	r.Header.Set("If-None-Match", `"`+file.md5+`"`)
+	w := serve(s, r)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("If-None-Match: got %d", w.Code)
+	}
+	for _, name := range []string{"ETag", "Last-Modified", "Cache-Control"} {
+		if w.Header().Get(name) != "" {
+			t.Errorf("%s sent for a denied file", name)
+		}
+	}
+
+	if w := serve(s, httptest.NewRequest("GET", "/gridfs/a.exe?w=10", nil)); w.Code != http.StatusForbidden {
+		t.Errorf("resize: got %d", w.Code)
+	}
+}
+
+func TestZipSkipsDeniedFiles(t *testing.T) {
+
+	conf := testConfig()
+	conf.ZipPath = "/zip/"
+	conf.AllowedTypes = []string{"image/*"}
+	s, gfs := newTestServer(t, conf)
+	gfs.put("a.png", "png", "image/png", nil)
+	gfs.put("b", "GIF89a", "", nil)
+	gfs.put("c.exe", "MZ", "application/octet-stream", nil)
+
+	w := serve(s, httptest.NewRequest("GET", "/zip/?file=a.png&file=b&file=c.exe", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %q", w.Code, w.Body.String())
+	}
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range archive.File {
+		names = append(names, entry.Name)
+		if entry.Name == "missing.txt" {
+			f, _ := entry.Open()
+			missing, _ := io.ReadAll(f)
+			f.Close()
+			if string(missing) != "c.exe\n" {
+				t.Errorf("missing.txt: %q", missing)
+			}
+		}
+	}
+	// b has no declared type but is sniffed as a gif
+	if len(names) != 3 || names[0] != "a.png" || names[1] != "b" || names[2] != "missing.txt" {
+		t.Errorf("entries: %v", names)
+	}
+}
+
+func TestMetaHidesDeniedFiles(t *testing.T) {
+
+	conf := testConfig()
+	conf.MetaPath = "/meta/"
+	conf.AllowedTypes = []string{"image/*"}
+	s, gfs := newTestServer(t, conf)
+	gfs.put("a.png", "png", "image/png", nil)
+	gfs.put("b", "GIF89a", "", nil)
+	gfs.put("c.exe", "MZ", "application/octet-stream", nil)
+	gfs.put("d", "MZ", "", nil)
+
+	for path, want := range map[string]int{
+		"/meta/a.png":          http.StatusOK,
+		"/meta/b":              http.StatusOK,
+		"/meta/c.exe":          http.StatusForbidden,
+		"/meta/c.exe?verify=1": http.StatusForbidden,
+		"/meta/d":              http.StatusForbidden,
+	} {
+		w := serve(s, httptest.NewRequest("GET", path, nil))
+		if w.Code != want {
+			t.Errorf("%s: got %d %q, want %d", path, w.Code, w.Body.String(), want)
+		}
+		if want == http.StatusForbidden && strings.Contains(w.Body.String(), "md5") {
+			t.Errorf("%s: revealed %q", path, w.Body.String())
+		}
+	}
+}
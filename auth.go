@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+)
+
+// check basic auth credentials if configured
+// answers with 401 and returns false when they are missing or wrong
+func (s *server) checkAuth(w http.ResponseWriter, r *http.Request, conf config) bool {
+
+	if conf.AuthUser == "" && conf.AuthPass == "" {
+		return true
+	}
+
+	user, pass, ok := r.BasicAuth()
+	if ok && secureCompare(user, conf.AuthUser) && secureCompare(pass, conf.AuthPass) {
+		return true
+	}
+
+	w.Header().Set("WWW-Authenticate", `Basic realm="gogridfs", charset="UTF-8"`)
+	s.writeError(w, r, "unauthorized", http.StatusUnauthorized)
+
+	return false
+}
+
+// compare strings in constant time
+// hashing first keeps the length of the secret from leaking
+func secureCompare(given string, expected string) bool {
+	givenSum := sha256.Sum256([]byte(given))
+	expectedSum := sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(givenSum[:], expectedSum[:]) == 1
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// set the Cache-Control header for a file of the given content type
+// an exact content type override wins over a "type/*" one and the default
+func setCacheControl(w http.ResponseWriter, conf config, ctype string) {
+
+	value := conf.CacheControl
+
+	if mediatype, _, err := mime.ParseMediaType(ctype); err == nil {
+		if byType, ok := conf.CacheControlByType[mediatype]; ok {
+			value = byType
+		} else if slash := strings.Index(mediatype, "/"); slash > 0 {
+			if byType, ok := conf.CacheControlByType[mediatype[:slash]+"/*"]; ok {
+				value = byType
+			}
+		}
+	}
+
+	if value != "" {
+		w.Header().Set("Cache-Control", value)
+	}
+}
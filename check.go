@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// check the config file and the mongodb connections of all mounts without serving anything
+// returns the exit code, 0 if everything is fine
+func checkConfig(file string) int {
+
+	logger := log.New(os.Stderr, "", 5)
+
+	conf, err := loadConfig(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	err = validateConfig(conf)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println("config ok:", file)
+
+	if conf.TLSCert != "" && conf.TLSKey != "" {
+		_, err = loadTLSConfig(conf.TLSCert, conf.TLSKey)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "unable to load TLS certificate and key:", err)
+			return 1
+		}
+		fmt.Println("tls ok:", conf.TLSCert)
+	}
+
+	mgo_session, err := dialMongo(logger, conf)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "unable to connect to mongodb:", err)
+		return 1
+	}
+	defer mgo_session.Close()
+
+	err = mgo_session.Ping()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "unable to ping mongodb:", err)
+		return 1
+	}
+	// the connection string may contain credentials, so name the servers only
+	fmt.Println("mongodb ok:", mgo_session.LiveServers())
+
+	// mounts with their own servers or mongouri are dialed like when serving
+	for _, mc := range mountConnections(conf) {
+		dialed := conf
+		dialed.Servers, dialed.MongoURI = mc.Servers, mc.MongoURI
+		mount_session, err := dialMongo(logger, dialed)
+		if err == nil {
+			err = mount_session.Ping()
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "unable to connect to mongodb of mount", mc.HandlePath+":", err)
+			if mount_session != nil {
+				mount_session.Close()
+			}
+			return 1
+		}
+		fmt.Println("mongodb ok for mount", mc.HandlePath+":", mount_session.LiveServers())
+		mount_session.Close()
+	}
+
+	return 0
+}
+
+// first mount of every connection other than the top level one
+func mountConnections(conf config) (mounts []mountConfig) {
+
+	seen := map[string]bool{"": true, connection(conf.Servers, conf.MongoURI): true}
+	for _, mc := range mountConfigs(conf) {
+		key := connection(mc.Servers, mc.MongoURI)
+		if !seen[key] {
+			seen[key] = true
+			mounts = append(mounts, mc)
+		}
+	}
+
+	return
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parse trusted proxies given as CIDRs or single addresses
+func parseProxies(proxies []string) (nets []*net.IPNet, err error) {
+
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+
+		var ipnet *net.IPNet
+		_, ipnet, err = net.ParseCIDR(proxy)
+		if err != nil {
+			return
+		}
+		nets = append(nets, ipnet)
+	}
+
+	return
+}
+
+// check whether the address belongs to a trusted proxy
+func (s *server) isTrustedProxy(addr string) bool {
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+
+	for _, ipnet := range s.TrustedProxies {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ip address of the requesting client
+// X-Forwarded-For and X-Real-IP are honored for requests from trusted proxies only,
+// the rightmost forwarded address that isn't a trusted proxy is the client
+func (s *server) clientIP(r *http.Request) string {
+
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+
+	if !s.isTrustedProxy(peer) {
+		return peer
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		addrs := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(addrs) - 1; i >= 0; i-- {
+			addr := strings.TrimSpace(addrs[i])
+			if addr != "" && !s.isTrustedProxy(addr) {
+				return addr
+			}
+		}
+	}
+
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		return realIP
+	}
+
+	return peer
+}
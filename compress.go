@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// content types worth compressing on the fly
+// images, video and archives are compressed already
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/javascript": true,
+	"image/svg+xml":          true,
+}
+
+// check whether content of the given type should be compressed
+func isCompressible(ctype string) bool {
+
+	mediatype, _, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(mediatype, "text/") || compressibleTypes[mediatype]
+}
+
+// check whether the client accepts the given content coding
+func acceptsEncoding(r *http.Request, coding string) bool {
+
+	for _, header := range r.Header["Accept-Encoding"] {
+		for _, candidate := range strings.Split(header, ",") {
+			fields := strings.Split(candidate, ";")
+			if !strings.EqualFold(strings.TrimSpace(fields[0]), coding) {
+				continue
+			}
+			// q=0 means not acceptable
+			for _, param := range fields[1:] {
+				param = strings.TrimSpace(param)
+				if !strings.HasPrefix(param, "q=") {
+					continue
+				}
+				if q, err := strconv.ParseFloat(param[len("q="):], 64); err == nil && q == 0 {
+					return false
+				}
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+// content coding of files stored compressed, empty for plain files
+// taken from metadata.encoding, e.g. "br", or the metadata.gzip flag
+func storedEncoding(gfsFile gridFile) string {
+
+	var meta struct {
+		Encoding string `bson:"encoding"`
+		Gzip     bool   `bson:"gzip"`
+	}
+	if err := gfsFile.GetMeta(&meta); err != nil {
+		return ""
+	}
+
+	if meta.Encoding != "" {
+		return strings.ToLower(meta.Encoding)
+	}
+	if meta.Gzip {
+		return "gzip"
+	}
+
+	return ""
+}
+
+// writer decompressing the gzip data written to it into the underlying writer
+type gunzipWriter struct {
+	pipe *io.PipeWriter
+	done chan error
+}
+
+// start decompressing into w, Close waits for the decompression to finish
+func newGunzipWriter(w io.Writer) *gunzipWriter {
+
+	pr, pw := io.Pipe()
+	gw := &gunzipWriter{pipe: pw, done: make(chan error, 1)}
+	go func() {
+		zr, err := gzip.NewReader(pr)
+		if err == nil {
+			_, err = io.Copy(w, zr)
+		}
+		// fail further writes once decompression stopped
+		pr.CloseWithError(err)
+		gw.done <- err
+	}()
+
+	return gw
+}
+
+func (gw *gunzipWriter) Write(b []byte) (int, error) {
+	return gw.pipe.Write(b)
+}
+
+func (gw *gunzipWriter) Close() error {
+	gw.pipe.Close()
+	return <-gw.done
+}
+
+// read and gzip a whole file in memory, so the compressed length is known
+// before anything is sent
+// with verify the returned writer has hashed the uncompressed content
+// concurrent requests for the same content share one read
+func (s *server) gzipFile(ctx context.Context, gfsFile gridFile, verify bool) (data []byte, verifier *md5Writer, err error) {
+
+	type gzipped struct {
+		data     []byte
+		verifier *md5Writer
+	}
+
+	key := fmt.Sprintf("gzip\x00%v\x00%s\x00%d\x00%t", gfsFile.Id(), gfsFile.MD5(), gfsFile.Size(), verify)
+	shared, err := s.coalesce(ctx, key, func() (interface{}, error) {
+		data, verifier, err := s.readGzipped(ctx, gfsFile, verify)
+		return gzipped{data, verifier}, err
+	})
+	if err != nil {
+		return
+	}
+
+	return shared.(gzipped).data, shared.(gzipped).verifier, nil
+}
+
+// read and gzip a whole file in memory
+func (s *server) readGzipped(ctx context.Context, gfsFile gridFile, verify bool) (data []byte, verifier *md5Writer, err error) {
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+
+	var body io.Writer = gz
+	if verify && gfsFile.MD5() != "" {
+		verifier = newMD5Writer(gz)
+		body = verifier
+	}
+
+	_, err = s.streamFile(ctx, body, gfsFile, gfsFile.Size())
+	if err != nil {
+		return
+	}
+	err = gz.Close()
+	if err != nil {
+		return
+	}
+
+	return compressed.Bytes(), verifier, nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// take a slot for a file download, bounded by MaxConcurrent
+// requests wait up to MaxConcurrentWait milliseconds for a free slot
+// and are answered with 503 afterwards
+func (s *server) acquireSlot(w http.ResponseWriter, r *http.Request) bool {
+
+	if s.Slots == nil {
+		s.Metrics.downloadStarted()
+		return true
+	}
+
+	select {
+	case s.Slots <- struct{}{}:
+		s.Metrics.downloadStarted()
+		return true
+	default:
+	}
+
+	wait := time.Duration(s.conf().MaxConcurrentWait) * time.Millisecond
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		select {
+		case s.Slots <- struct{}{}:
+			s.Metrics.downloadStarted()
+			return true
+		case <-timer.C:
+		case <-r.Context().Done():
+		}
+	}
+
+	w.Header().Set("Retry-After", "1")
+	s.writeError(w, r, "too many concurrent downloads", http.StatusServiceUnavailable)
+
+	return false
+}
+
+// free the slot of a finished download
+func (s *server) releaseSlot() {
+
+	s.Metrics.downloadFinished()
+	if s.Slots != nil {
+		<-s.Slots
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// quoted entity tag of a gridfile derived from its stored md5
+// empty if the file has no md5
+func fileETag(md5 string) string {
+	if md5 == "" {
+		return ""
+	}
+	return `"` + md5 + `"`
+}
+
+// headers checksumheader may name
+var checksumHeaders = map[string]bool{"digest": true, "content-md5": true}
+
+// send the stored md5 as Digest: md5=<base64> of RFC 3230 or as the legacy Content-MD5
+// nothing is sent if disabled or the file has no md5
+func setChecksum(w http.ResponseWriter, kind string, md5hex string) {
+
+	sum, err := hex.DecodeString(md5hex)
+	if kind == "" || err != nil || len(sum) != md5.Size {
+		return
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(sum)
+	if strings.ToLower(kind) == "content-md5" {
+		w.Header().Set("Content-MD5", encoded)
+	} else {
+		w.Header().Set("Digest", "md5="+encoded)
+	}
+}
+
+// check whether an If-None-Match header matches the entity tag
+// uses the weak comparison of RFC 7232
+func etagMatch(header string, etag string) bool {
+
+	if etag == "" {
+		return false
+	}
+
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// check the If-None-Match and If-Match headers of an upload against the stored file
+// If-None-Match: * only creates missing files, If-Match: * only replaces existing ones,
+// entity tags of If-Match use the strong comparison
+func uploadPrecondition(header http.Header, exists bool, etag string) bool {
+
+	if cond := header.Get("If-None-Match"); cond != "" && exists {
+		if strings.TrimSpace(cond) == "*" || etagMatch(cond, etag) {
+			return false
+		}
+	}
+
+	if cond := header.Get("If-Match"); cond != "" {
+		if !exists {
+			return false
+		}
+		for _, candidate := range strings.Split(cond, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || (etag != "" && candidate == etag) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// check whether a file modified at modtime is unchanged since the
+// If-Modified-Since header, compared at second granularity
+func notModifiedSince(header string, modtime time.Time) bool {
+
+	since, err := http.ParseTime(header)
+	if err != nil {
+		return false
+	}
+
+	return !modtime.Truncate(time.Second).After(since)
+}
+
+// check whether the If-Range header still matches the file, so
+// the requested range may be served instead of the whole file
+// entity tags use the strong comparison, dates must match exactly
+func ifRangeMatch(header string, etag string, modtime time.Time) bool {
+
+	header = strings.TrimSpace(header)
+	if strings.HasPrefix(header, `"`) || strings.HasPrefix(header, "W/") {
+		return etag != "" && header == etag
+	}
+
+	date, err := http.ParseTime(header)
+	if err != nil {
+		return false
+	}
+
+	return modtime.Truncate(time.Second).Equal(date)
+}
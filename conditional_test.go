@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChecksumHeader(t *testing.T) {
+
+	sum := md5.Sum([]byte("hello world"))
+	encoded := base64.StdEncoding.EncodeToString(sum[:])
+
+	for kind, want := range map[string]string{"digest": "md5=" + encoded, "content-md5": encoded} {
+		conf := testConfig()
+		conf.ChecksumHeader = kind
+		conf.Compress = true
+		s, gfs := newTestServer(t, conf)
+		gfs.put("a.txt", "hello world", "text/plain", nil)
+
+		name := "Digest"
+		if kind == "content-md5" {
+			name = "Content-MD5"
+		}
+		if w := serve(s, httptest.NewRequest("GET", "/gridfs/a.txt", nil)); w.Header().Get(name) != want {
+			t.Errorf("%s: got %q", kind, w.Header().Get(name))
+		}
+
+		// the stored md5 doesn't cover ranges or bodies gzipped on the fly
+		r := httptest.NewRequest("GET", "/gridfs/a.txt", nil)
+		r.Header.Set("Range", "bytes=0-4")
+		if w := serve(s, r); w.Code != http.StatusPartialContent || w.Header().Get(name) != "" {
+			t.Errorf("%s range: got %d %q", kind, w.Code, w.Header().Get(name))
+		}
+		r = httptest.NewRequest("GET", "/gridfs/a.txt", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		if w := serve(s, r); w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get(name) != "" {
+			t.Errorf("%s gzip: got %q %q", kind, w.Header().Get("Content-Encoding"), w.Header().Get(name))
+		}
+	}
+}
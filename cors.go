@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// emit CORS headers if configured and answer preflight requests
+// returns true when the request has been answered
+func handleCORS(w http.ResponseWriter, r *http.Request, conf config) bool {
+
+	if len(conf.CORSAllowOrigin) == 0 {
+		return false
+	}
+
+	origin := r.Header.Get("Origin")
+	allowed := ""
+	for _, candidate := range conf.CORSAllowOrigin {
+		if candidate == "*" {
+			allowed = "*"
+			break
+		}
+		if origin != "" && candidate == origin {
+			allowed = origin
+			break
+		}
+	}
+
+	// the allowed origin depends on the request origin
+	if allowed != "*" {
+		w.Header().Add("Vary", "Origin")
+	}
+	if allowed == "" {
+		return false
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", allowed)
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range, Content-Disposition, ETag")
+
+	if r.Method != "OPTIONS" || r.Header.Get("Access-Control-Request-Method") == "" {
+		return false
+	}
+
+	// preflight
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowedMethods(conf), ", "))
+	if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+		w.Header().Set("Access-Control-Allow-Headers", headers)
+	}
+	w.WriteHeader(http.StatusNoContent)
+
+	return true
+}
+
+// methods file requests may use, OPTIONS only for CORS preflights
+func allowedMethods(conf config) (methods []string) {
+
+	methods = []string{"GET", "HEAD"}
+	if len(conf.CORSAllowOrigin) > 0 {
+		methods = append(methods, "OPTIONS")
+	}
+	if conf.AllowUpload {
+		methods = append(methods, "PUT", "POST")
+	}
+	if conf.AllowDelete {
+		methods = append(methods, "DELETE")
+	}
+
+	return
+}
+
+// answer with 405 and the methods that are allowed
+func (s *server) methodNotAllowed(w http.ResponseWriter, r *http.Request, conf config) {
+	w.Header().Set("Allow", strings.Join(allowedMethods(conf), ", "))
+	s.writeError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// config printed by -print-default-config
+// fields left out have the zero value as default
+var exampleConfig = config{
+	Servers:          []string{"localhost:27017"},
+	Database:         "gofiles",
+	GridFSCollection: "fs",
+	Field:            "filename",
+	Listen:           "localhost:4242",
+	HandlePath:       "/gridfs/",
+	NotFoundStatus:   404,
+	Disposition:      "attachment",
+	ShutdownTimeout:  30,
+	MaxRetries:       1,
+	RetryBackoff:     100,
+	ReadBufferSize:   32 * 1024,
+	LogFormat:        "text",
+	StatsTTL:         60,
+	MaintenanceRetry: 60,
+	LogLevel:         "info",
+	Mode:             "strong",
+	MaxUploadBytes:   defaultMaxUploadBytes,
+}
+
+// descriptions of the config fields by name, printed above them
+var configDocs = map[string]string{
+	"servers":            "mongodb servers, at least one of the cluster",
+	"mongouri":           "mongodb:// connection string replacing servers, its database is used if database is empty",
+	"mongouser":          "mongodb user, logged in after connecting",
+	"mongopassword":      "password of the mongodb user",
+	"authdatabase":       "database the mongodb user is defined in, default admin",
+	"mongotls":           "connect to mongodb with tls",
+	"mongocafile":        "CA certificates verifying mongodb instead of the system ones",
+	"mongoinsecure":      "skip verifying the mongodb certificates, for testing only",
+	"logfile":            "log file, stdout if empty",
+	"database":           "database containing the GridFS",
+	"gridfscollection":   "GridFS root collection, a trailing .files or .chunks is trimmed",
+	"field":              "field files are looked up by: filename, _id or metadata.<key>, ?by=id or ?by=filename overrides it",
+	"listen":             `address to listen on, ":4242" for all addresses, "unix:/run/gogridfs.sock" for a unix socket`,
+	"listeners":          "several addresses like listen, replacing it",
+	"adminlisten":        "private address of the admin endpoints like /debug/config and /debug/warmup",
+	"profiling":          "serve pprof below /debug/pprof/ on adminlisten",
+	"handlepath":         "path files are served below, it is cut from the requested filename",
+	"metapath":           "path returning file information as JSON, disabled if empty",
+	"listpath":           "path listing files by prefix as JSON, disabled if empty",
+	"zippath":            "path returning zip archives of several files, disabled if empty",
+	"pathpatterns":       `further routes serving files named by their path wildcard, e.g. ["/img/{path...}"]`,
+	"stripprefix":        "removed from request paths before routing",
+	"pathprefix":         "prepended to requested filenames",
+	"allowedextensions":  `extensions served files must have, e.g. [".png"], empty allows all`,
+	"allowedtypes":       `content types served files must have, e.g. ["image/*"], empty allows all`,
+	"indexfile":          "file served for requests ending in /, e.g. index.html",
+	"notfoundfile":       "file served in place of missing files",
+	"notfoundstatus":     "status of the notfoundfile",
+	"disposition":        "Content-Disposition of files: attachment, inline or none, ?download=1 or ?download=0 forces attachment or inline",
+	"dispositionpath":    "offer the full stored path as filename instead of the base name",
+	"cachecontrol":       "Cache-Control header of served files",
+	"cachecontrolbytype": `Cache-Control by content type, e.g. {"image/*": "public, max-age=604800"}`,
+	"extraheaders":       "headers added to every file response",
+	"healthpath":         "health check path, disabled if empty",
+	"shutdowntimeout":    "seconds in-flight requests may finish on SIGINT or SIGTERM",
+	"tlscert":            "certificate file, https is served with tlscert and tlskey",
+	"tlskey":             "key file of the certificate",
+	"tlsredirectlisten":  "plain http address redirecting to https",
+	"h2c":                "accept HTTP/2 without tls",
+	"authuser":           "basic auth user required for files",
+	"authpass":           "basic auth password required for files",
+	"signingsecret":      "secret of signed urls",
+	"corsalloworigin":    `origins allowed to fetch files from browsers, ["*"] allows any`,
+	"ratelimitrps":       "requests per second per client ip, 0 disables",
+	"ratelimitburst":     "burst of requests per client ip",
+	"maxconcurrent":      "downloads served at once, 0 means unlimited",
+	"maxconcurrentwait":  "milliseconds downloads wait for a free slot before 503",
+	"maxbytespersecond":  "bytes per second sent per download, 0 means unlimited",
+	"trustedproxies":     "proxies whose X-Forwarded-For and X-Real-IP name the client",
+	"mounts":             "several GridFS collections with their own handlepath, replacing the top level one, optionally on their own servers or mongouri and in their own mode",
+	"alloweddatabases":   "databases selectable with the X-Database header",
+	"maxretries":         "retries of failed lookups, -1 disables",
+	"retrybackoff":       "milliseconds before the first retry, doubled for each further one",
+	"buffermaxbytes":     "compressible files up to this size are gzipped in memory, 0 always streams",
+	"readbuffersize":     "bytes read from GridFS at once",
+	"verifymd5":          "hash served files and log corrupted ones",
+	"checksumheader":     "send the stored md5 of whole files as digest (Digest: md5=...) or content-md5, none if empty",
+	"logformat":          "access log format: text or json",
+	"logexcludepaths":    "path patterns left out of the access log unless they fail",
+	"logsamplerate":      "share of successful requests access logged, 0 logs all",
+	"tracingendpoint":    "OTLP/HTTP url spans are exported to, disabled if empty",
+	"plainerrors":        "answer errors in plain text instead of JSON",
+	"metricspath":        "prometheus metrics path, disabled if empty",
+	"statspath":          "storage statistics path, disabled if empty",
+	"statsttl":           "seconds storage statistics are cached",
+	"typecachesize":      "content types sniffed from files without a stored one kept in memory, 0 keeps 1024, -1 disables",
+	"typecachettl":       "seconds sniffed content types are kept, 0 means 300",
+	"runtimepath":        "uptime and request counters as JSON without prometheus, disabled if empty",
+	"versionpath":        "build information path, disabled if empty",
+	"readtimeout":        "seconds to read a request, 0 means no timeout",
+	"writetimeout":       "seconds to write a response, 0 means no timeout",
+	"idletimeout":        "seconds idle connections are kept open",
+	"startuptimeout":     "seconds to wait for mongodb at startup, 0 tries once",
+	"mongotimeout":       "seconds to wait for GridFS lookups and for every read of a download, which may take longer as a whole, 0 means no timeout",
+	"maintenance":        "answer requests with 503 during database maintenance",
+	"maintenanceretry":   "seconds sent in Retry-After during maintenance",
+	"loglevel":           "debug, info, warn or error",
+	"debug":              "same as loglevel debug",
+	"mode":               "mgo mode: strong, monotonic or eventual",
+	"readpreference":     "replica set read preference overriding mode",
+	"strongwrites":       "uploads and deletes on the primary while reads follow mode",
+	"poollimit":          "sockets per mongodb server, 0 keeps the mgo default",
+	"compress":           "gzip compressible responses for clients accepting it",
+	"enableimageresize":  "resize images to ?w= and ?h=",
+	"maxuploadbytes":     "larger uploads are answered with 413, -1 means unlimited, whole form bodies count",
+	"overwritereplace":   "remove older versions once an upload is stored",
+	"allowupload":        "store files sent with PUT or POSTed by multipart/form-data html forms",
+	"allowdelete":        "remove files on DELETE",
+}
+
+// write a commented YAML config with every field of the config struct and its default
+func printDefaultConfig(w io.Writer) (err error) {
+
+	fmt.Fprintln(w, "# gogridfs config with every field and its default, save it as config.yaml")
+
+	value := reflect.ValueOf(exampleConfig)
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Tag.Get("yaml")
+		if name == "" {
+			continue
+		}
+
+		fmt.Fprintln(w)
+		if doc := configDocs[name]; doc != "" {
+			fmt.Fprintln(w, "# "+strings.ReplaceAll(doc, "\n", "\n# "))
+		}
+		var field []byte
+		field, err = yaml.Marshal(map[string]interface{}{name: value.Field(i).Interface()})
+		if err != nil {
+			return
+		}
+		_, err = w.Write(field)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// write a JSON schema of the config file, for editors checking config.json as it is written
+// like the default config it is derived from the config struct
+func printConfigSchema(w io.Writer) error {
+
+	schema := typeSchema(reflect.TypeOf(exampleConfig), reflect.ValueOf(exampleConfig))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "gogridfs config"
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(schema)
+}
+
+// JSON schema of a config type with the descriptions of configDocs
+// defaults are taken from the example value if it is valid
+// unknown fields are ignored when loading, the schema flags them as they are likely typos
+func typeSchema(t reflect.Type, example reflect.Value) map[string]interface{} {
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), reflect.Value{})}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), reflect.Value{})}
+	case reflect.Struct:
+		properties := map[string]interface{}{}
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+			if name == "" || name == "-" {
+				continue
+			}
+
+			var value reflect.Value
+			if example.IsValid() {
+				value = example.Field(i)
+			}
+			property := typeSchema(t.Field(i).Type, value)
+			if doc := configDocs[name]; doc != "" {
+				property["description"] = doc
+			}
+			if value.IsValid() && !value.IsZero() {
+				property["default"] = value.Interface()
+			}
+			properties[name] = property
+		}
+		return map[string]interface{}{"type": "object", "properties": properties, "additionalProperties": false}
+	}
+
+	return map[string]interface{}{}
+}
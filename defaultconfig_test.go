@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+type schemaNode struct {
+	Type                 string                `json:"type"`
+	Description          string                `json:"description"`
+	Default              interface{}           `json:"default"`
+	Items                *schemaNode           `json:"items"`
+	Properties           map[string]schemaNode `json:"properties"`
+	AdditionalProperties interface{}           `json:"additionalProperties"`
+}
+
+func TestConfigSchema(t *testing.T) {
+
+	var buffer bytes.Buffer
+	if err := printConfigSchema(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	var schema schemaNode
+	if err := json.Unmarshal(buffer.Bytes(), &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	if schema.Type != "object" || schema.AdditionalProperties != false {
+		t.Errorf("top level: %+v", schema)
+	}
+	if servers := schema.Properties["servers"]; servers.Type != "array" || servers.Items == nil || servers.Items.Type != "string" || servers.Description == "" {
+		t.Errorf("servers: %+v", servers)
+	}
+	if buffer := schema.Properties["readbuffersize"]; buffer.Type != "integer" || buffer.Default != float64(32768) {
+		t.Errorf("readbuffersize: %+v", buffer)
+	}
+	if debug := schema.Properties["debug"]; debug.Type != "boolean" || debug.Default != nil {
+		t.Errorf("debug: %+v", debug)
+	}
+	mounts := schema.Properties["mounts"]
+	if mounts.Type != "array" || mounts.Items == nil || mounts.Items.Properties["handlepath"].Type != "string" {
+		t.Fatalf("mounts: %+v", mounts)
+	}
+
+	// the schema covers the sample config
+	var sample map[string]interface{}
+	data, _ := json.Marshal(exampleConfig)
+	json.Unmarshal(data, &sample)
+	for name := range sample {
+		if _, ok := schema.Properties[name]; !ok {
+			t.Errorf("%s missing from the schema", name)
+		}
+	}
+}
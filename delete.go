@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+)
+
+// remove file from gridfs
+// with the filename field all versions of the file are removed,
+// with a metadata field all matching files
+func deleteFile(gfs gridStore, value string, field string) (err error) {
+
+	if field == "_id" {
+		for _, id := range idValues(value) {
+			if err = gfs.RemoveId(id); err != mgo.ErrNotFound {
+				return
+			}
+		}
+		return
+	}
+
+	if !isMetaField(field) {
+		field = "filename"
+	}
+
+	var doc struct {
+		Id interface{} `bson:"_id"`
+	}
+	found := false
+	iter := gfs.Find(bson.M{field: value}).Select(bson.M{"_id": 1}).Iter()
+	for iter.Next(&doc) {
+		found = true
+		if err = gfs.RemoveId(doc.Id); err != nil {
+			iter.Close()
+			return
+		}
+	}
+	if err = iter.Close(); err != nil {
+		return
+	}
+
+	if !found {
+		return mgo.ErrNotFound
+	}
+
+	return
+}
+
+// handle DELETE requests for the mount
+func (m *mount) deleteHandler(w http.ResponseWriter, r *http.Request, path string) {
+
+	err := deleteFile(m.WriteGFS, path, m.Field)
+	if err == mgo.ErrNotFound {
+		m.srv.writeError(w, r, "file not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		m.srv.mongoError()
+		m.srv.logError(w, err)
+		m.srv.writeError(w, r, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// only _id lookups may see the id again, other ids are gone for good
+	if m.Field == "_id" {
+		for _, id := range idValues(path) {
+			m.srv.Types.remove(m.typeKey(id))
+		}
+	}
+
+	// deletions are logged at warn level, so they stay in the log at every level but error
+	// behind a trusted proxy the client address is the forwarded one
+	m.srv.warn("deleted", path, "of", m.HandlePath, "by", m.srv.clientIP(r))
+
+	w.WriteHeader(http.StatusNoContent)
+}
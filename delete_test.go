@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDeleteFile(t *testing.T) {
+
+	conf := testConfig()
+	conf.AllowDelete = true
+	conf.LogLevel = "warn"
+	conf.TrustedProxies = []string{"10.0.0.0/8"}
+	s, gfs := newTestServer(t, conf)
+	var logged bytes.Buffer
+	s.Logger = log.New(&logged, "", 0)
+	gfs.put("a.txt", "first", "text/plain", nil)
+	gfs.put("a.txt", "second", "text/plain", nil)
+
+	// every version of the name goes
+	r := httptest.NewRequest("DELETE", "/gridfs/a.txt", nil)
+	r.RemoteAddr = "10.1.2.3:4567"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9")
+	if w := serve(s, r); w.Code != http.StatusNoContent || len(gfs.names()) != 0 {
+		t.Fatalf("got %d, left %v", w.Code, gfs.names())
+	}
+	if !strings.Contains(logged.String(), "deleted a.txt of /gridfs/ by 203.0.113.9") {
+		t.Errorf("logged %q", logged.String())
+	}
+
+	if w := serve(s, httptest.NewRequest("DELETE", "/gridfs/a.txt", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("missing file: got %d", w.Code)
+	}
+
+	conf.AllowDelete = false
+	s, gfs = newTestServer(t, conf)
+	gfs.put("a.txt", "first", "text/plain", nil)
+	if w := serve(s, httptest.NewRequest("DELETE", "/gridfs/a.txt", nil)); w.Code != http.StatusMethodNotAllowed || len(gfs.names()) != 1 {
+		t.Errorf("without allowdelete: got %d, left %v", w.Code, gfs.names())
+	}
+}
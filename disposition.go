@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// disposition type of the response, empty for no Content-Disposition
+// the disposition and download query parameters override the configured default
+func dispositionType(r *http.Request, conf config) string {
+
+	query := r.URL.Query()
+	switch query.Get("disposition") {
+	case "inline":
+		return "inline"
+	case "attachment":
+		return "attachment"
+	case "none":
+		return ""
+	}
+	// ?download=1 forces a save dialog, ?download=0 showing the file
+	switch query.Get("download") {
+	case "1":
+		return "attachment"
+	case "0":
+		return "inline"
+	}
+
+	switch conf.Disposition {
+	case "inline":
+		return "inline"
+	case "none":
+		return ""
+	}
+
+	return "attachment"
+}
+
+// filename offered to clients for the stored name
+// browsers mishandle slashes, so only the base name is kept unless configured otherwise
+func dispositionName(name string, conf config) string {
+
+	if conf.DispositionPath {
+		return name
+	}
+
+	return path.Base(name)
+}
+
+// value for the Content-Disposition header
+// non ascii filenames get an RFC 5987 filename* parameter
+// next to an ascii fallback for older clients
+func contentDisposition(dtype string, filename string) string {
+
+	value := dtype + `; filename="` + quoteFilename(filename) + `"`
+	if !isASCII(filename) {
+		value += "; filename*=UTF-8''" + encodeRFC5987(filename)
+	}
+
+	return value
+}
+
+// ascii version of the filename for the quoted filename parameter
+// quotes and backslashes are escaped, other characters replaced by "_"
+func quoteFilename(filename string) string {
+
+	var quoted strings.Builder
+	for _, c := range filename {
+		switch {
+		case c == '"' || c == '\\':
+			quoted.WriteRune('\\')
+			quoted.WriteRune(c)
+		case c < 0x20 || c >= 0x7f:
+			quoted.WriteRune('_')
+		default:
+			quoted.WriteRune(c)
+		}
+	}
+
+	return quoted.String()
+}
+
+// percent encode everything but the attr-chars of RFC 5987
+func encodeRFC5987(s string) string {
+
+	var encoded strings.Builder
+	for _, b := range []byte(s) {
+		if isAttrChar(b) {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+
+	return encoded.String()
+}
+
+// attr-char of RFC 5987
+func isAttrChar(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9' ||
+		strings.IndexByte("!#$&+-.^_`|~", b) >= 0
+}
+
+// check whether the string consists of printable ascii only
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
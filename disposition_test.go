@@ -0,0 +1,76 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestContentDisposition(t *testing.T) {
+
+	for _, test := range []struct {
+		filename string
+		want     string
+	}{
+		{"report.pdf", `attachment; filename="report.pdf"`},
+		{"annual report.pdf", `attachment; filename="annual report.pdf"`},
+		{`say "hi"\.txt`, `attachment; filename="say \"hi\"\\.txt"`},
+		{"отчёт.pdf", `attachment; filename="_____.pdf"; filename*=UTF-8''%D0%BE%D1%82%D1%87%D1%91%D1%82.pdf`},
+		{"报告 2024.pdf", `attachment; filename="__ 2024.pdf"; filename*=UTF-8''%E6%8A%A5%E5%91%8A%202024.pdf`},
+	} {
+		got := contentDisposition("attachment", test.filename)
+		if got != test.want {
+			t.Errorf("%s: got %s, want %s", test.filename, got, test.want)
+		}
+
+		// clients decoding the header get the original name back
+		dtype, params, err := mime.ParseMediaType(got)
+		if err != nil || dtype != "attachment" || params["filename"] != test.filename {
+			t.Errorf("%s: parsed as %s %q %v", test.filename, dtype, params, err)
+		}
+	}
+}
+
+func TestDispositionType(t *testing.T) {
+
+	for _, test := range []struct {
+		query string
+		conf  string
+		want  string
+	}{
+		{"", "", "attachment"},
+		{"", "inline", "inline"},
+		{"", "none", ""},
+		{"?disposition=inline", "", "inline"},
+		{"?disposition=none", "inline", ""},
+		{"?download=1", "inline", "attachment"},
+		{"?download=0", "", "inline"},
+		{"?disposition=attachment&download=0", "", "attachment"},
+	} {
+		r := httptest.NewRequest("GET", "/gridfs/a.txt"+test.query, nil)
+		if got := dispositionType(r, config{Disposition: test.conf}); got != test.want {
+			t.Errorf("%q with %q: got %q, want %q", test.query, test.conf, got, test.want)
+		}
+	}
+}
+
+func TestNestedDispositionName(t *testing.T) {
+
+	for _, keep := range []bool{false, true} {
+		conf := testConfig()
+		conf.DispositionPath = keep
+		s, gfs := newTestServer(t, conf)
+		gfs.put("docs/2024/report.pdf", "pdf", "application/pdf", nil)
+
+		want := `attachment; filename="report.pdf"`
+		if keep {
+			want = `attachment; filename="docs/2024/report.pdf"`
+		}
+		// the full name is still the one looked up
+		w := serve(s, httptest.NewRequest("GET", "/gridfs/docs/2024/report.pdf", nil))
+		if w.Code != http.StatusOK || w.Header().Get("Content-Disposition") != want {
+			t.Errorf("dispositionpath %t: got %d %q", keep, w.Code, w.Header().Get("Content-Disposition"))
+		}
+	}
+}
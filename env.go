@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// prefix of environment variables overriding config fields
+const envPrefix = "GOGRIDFS_"
+
+// override config fields with GOGRIDFS_<FIELD> environment variables
+// lists are comma separated, e.g. GOGRIDFS_SERVERS=host1:27017,host2:27017
+func applyEnv(conf *config) (err error) {
+
+	value := reflect.ValueOf(conf).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		name := envPrefix + strings.ToUpper(field.Name)
+
+		env, found := os.LookupEnv(name)
+		if !found {
+			continue
+		}
+
+		err = setField(value.Field(i), env)
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+
+	return
+}
+
+// set a config field from its string representation
+func setField(field reflect.Value, env string) (err error) {
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(env)
+	case reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(env)
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		var n int64
+		n, err = strconv.ParseInt(env, 10, field.Type().Bits())
+		field.SetInt(n)
+	case reflect.Float64:
+		var f float64
+		f, err = strconv.ParseFloat(env, 64)
+		field.SetFloat(f)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", field.Type())
+		}
+		var list []string
+		for _, item := range strings.Split(env, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		err = fmt.Errorf("unsupported type %s", field.Type())
+	}
+
+	return
+}
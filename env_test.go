@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// config file of the given content in a temporary directory
+func configFile(t *testing.T, name string, content string) string {
+
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func TestEnvOverridesConfigFile(t *testing.T) {
+
+	file := configFile(t, "config.json", `{"database": "file", "gridfscollection": "fs", "servers": ["file:27017"], "maxuploadbytes": 10}`)
+	t.Setenv("GOGRIDFS_DATABASE", "env")
+	t.Setenv("GOGRIDFS_SERVERS", "host1:27017, host2:27017,")
+	t.Setenv("GOGRIDFS_DEBUG", "true")
+	t.Setenv("GOGRIDFS_MAXUPLOADBYTES", "8589934592")
+	t.Setenv("GOGRIDFS_RATELIMITRPS", "2.5")
+
+	conf, err := loadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Database != "env" || !conf.Debug || conf.MaxUploadBytes != 8<<30 || conf.RateLimitRPS != 2.5 {
+		t.Errorf("got %+v", conf)
+	}
+	if !reflect.DeepEqual(conf.Servers, []string{"host1:27017", "host2:27017"}) {
+		t.Errorf("servers: got %q", conf.Servers)
+	}
+
+	// fields without a variable keep the file's value
+	if conf.GridFSCollection != "fs" {
+		t.Errorf("gridfscollection: got %q", conf.GridFSCollection)
+	}
+}
+
+func TestInvalidEnv(t *testing.T) {
+
+	file := configFile(t, "config.yaml", "database: file\n")
+	for name, value := range map[string]string{
+		"GOGRIDFS_DEBUG":              "maybe",
+		"GOGRIDFS_MAXCONCURRENT":      "many",
+		"GOGRIDFS_BUFFERMAXBYTES":     "1e9",
+		"GOGRIDFS_CACHECONTROLBYTYPE": "image/*=public",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := loadConfig(file); err == nil {
+				t.Errorf("%s=%s accepted", name, value)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// error response body
+type errorBody struct {
+	Error string `json:"error"`
+	Code  int    `json:"code"`
+	Path  string `json:"path"`
+}
+
+// answer a request with an error
+// the body is json unless plain text errors are configured
+func (s *server) writeError(w http.ResponseWriter, r *http.Request, message string, code int) {
+
+	// headers describing the file don't apply to the error
+	header := w.Header()
+	for _, name := range []string{"Content-Length", "Content-Encoding", "ETag", "Last-Modified", "Cache-Control"} {
+		header.Del(name)
+	}
+
+	if s.conf().PlainErrors {
+		http.Error(w, message, code)
+		return
+	}
+
+	header.Set("Content-Type", "application/json")
+	header.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(errorBody{Error: message, Code: code, Path: r.URL.Path})
+}
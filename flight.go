@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"labix.org/v2/mgo/bson"
+)
+
+// largest files whose content concurrent downloads share
+// larger ones are looked up and read by every request
+const maxSharedBytes = 1 << 20
+
+// lookup in progress, shared by concurrent identical requests
+type flight struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// coalesces concurrent calls with the same key into one
+// so a stampede on the same file makes one mongodb read
+type flightGroup struct {
+	lock    sync.Mutex
+	flights map[string]*flight
+}
+
+// run fn once for all concurrent callers with the same key and share its result
+// a canceled or timed out first caller doesn't fail the others, they run fn themselves
+func (s *server) coalesce(ctx context.Context, key string, fn func() (interface{}, error)) (interface{}, error) {
+
+	g := &s.Flights
+	g.lock.Lock()
+	if f, ok := g.flights[key]; ok {
+		g.lock.Unlock()
+		s.Metrics.coalesced()
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if (f.err == context.Canceled || f.err == context.DeadlineExceeded) && ctx.Err() == nil {
+			return fn()
+		}
+		return f.value, f.err
+	}
+	if g.flights == nil {
+		g.flights = make(map[string]*flight)
+	}
+	f := &flight{done: make(chan struct{})}
+	g.flights[key] = f
+	g.lock.Unlock()
+
+	f.value, f.err = fn()
+
+	g.lock.Lock()
+	delete(g.flights, key)
+	g.lock.Unlock()
+	close(f.done)
+
+	return f.value, f.err
+}
+
+// look up a file for a download, ctx bounds the lookup
+// concurrent whole GETs of the same file up to maxSharedBytes share one lookup
+// and one read of its content, each gets a copy with a read offset of its own
+// HEAD, range and conditional requests may not need all of it and look it up themselves
+func (m *mount) lookupFile(ctx context.Context, r *http.Request, name string, field string, version int) (gfsFile gridFile, err error) {
+
+	share := r.Method == "GET" && r.Header.Get("Range") == "" && r.Header.Get("If-None-Match") == "" && r.Header.Get("If-Modified-Since") == ""
+	if !share {
+		return m.srv.getFile(ctx, m.GFS, name, field, version)
+	}
+
+	var own gridFile
+	key := fmt.Sprintf("file\x00%s\x00%s\x00%s\x00%s\x00%s\x00%d", m.HandlePath, m.Database, m.GridFSCollection, field, name, version)
+	shared, err := m.srv.coalesce(ctx, key, func() (interface{}, error) {
+		gfsFile, err := m.srv.getFile(ctx, m.GFS, name, field, version)
+		if err != nil || gfsFile.Size() > maxSharedBytes {
+			own = gfsFile
+			return nil, err
+		}
+		// the content is read for as long as the first request lasts, not only the lookup
+		defer gfsFile.Close()
+		return m.srv.readShared(r.Context(), gfsFile)
+	})
+	if err != nil || own != nil {
+		return own, err
+	}
+
+	// larger files found by another request are looked up again
+	if shared == nil {
+		return m.srv.getFile(ctx, m.GFS, name, field, version)
+	}
+
+	return shared.(*sharedFile).open(), nil
+}
+
+// read the whole file into a shared copy
+func (s *server) readShared(ctx context.Context, gfsFile gridFile) (shared *sharedFile, err error) {
+
+	shared = &sharedFile{
+		id:         gfsFile.Id(),
+		name:       gfsFile.Name(),
+		ctype:      gfsFile.ContentType(),
+		uploadDate: gfsFile.UploadDate(),
+		md5:        gfsFile.MD5(),
+	}
+	if err = gfsFile.GetMeta(&shared.meta); err != nil {
+		return
+	}
+
+	var content bytes.Buffer
+	if _, err = s.streamFile(ctx, &content, gfsFile, gfsFile.Size()); err != nil {
+		return
+	}
+	shared.content = content.Bytes()
+
+	return
+}
+
+// read-only copy of a stored file shared by concurrent downloads
+type sharedFile struct {
+	id         interface{}
+	name       string
+	ctype      string
+	uploadDate time.Time
+	md5        string
+	meta       bson.Raw
+	content    []byte
+	reader     *bytes.Reader
+}
+
+var _ gridFile = (*sharedFile)(nil)
+
+var errReadOnly = errors.New("shared file is read-only")
+
+// opened copy of the shared file
+func (f *sharedFile) open() *sharedFile {
+	opened := *f
+	opened.reader = bytes.NewReader(f.content)
+	return &opened
+}
+
+func (f *sharedFile) Read(p []byte) (int, error) { return f.reader.Read(p) }
+func (f *sharedFile) Seek(offset int64, whence int) (int64, error) {
+	return f.reader.Seek(offset, whence)
+}
+func (f *sharedFile) Write(p []byte) (int, error)  { return 0, errReadOnly }
+func (f *sharedFile) Close() error                 { return nil }
+func (f *sharedFile) Abort()                       {}
+func (f *sharedFile) Id() interface{}              { return f.id }
+func (f *sharedFile) SetId(id interface{})         {}
+func (f *sharedFile) Name() string                 { return f.name }
+func (f *sharedFile) Size() int64                  { return int64(len(f.content)) }
+func (f *sharedFile) ContentType() string          { return f.ctype }
+func (f *sharedFile) SetContentType(ctype string)  {}
+func (f *sharedFile) UploadDate() time.Time        { return f.uploadDate }
+func (f *sharedFile) MD5() string                  { return f.md5 }
+func (f *sharedFile) SetMeta(metadata interface{}) {}
+
+func (f *sharedFile) GetMeta(result interface{}) error {
+
+	if f.meta.Kind == 0 {
+		return nil
+	}
+
+	return f.meta.Unmarshal(result)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCoalescedDownloads(t *testing.T) {
+
+	s, gfs := newTestServer(t, testConfig())
+	content := strings.Repeat("a", 1000)
+	gfs.put("a.txt", content, "text/plain", nil).delay = 50 * time.Millisecond
+
+	// requests running at once while the first one reads
+	download := func(n int, header string) {
+		gfs.lookups.Store(0)
+		gfs.read.Store(0)
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				r := httptest.NewRequest("GET", "/gridfs/a.txt", nil)
+				if header != "" {
+					r.Header.Set("Range", header)
+				}
+				w := serve(s, r)
+				if w.Code/100 != 2 || !strings.HasPrefix(content, w.Body.String()) {
+					t.Errorf("got %d with %d bytes", w.Code, w.Body.Len())
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	download(10, "")
+	if lookups, read := gfs.lookups.Load(), gfs.read.Load(); lookups != 1 || read != int64(len(content)) {
+		t.Errorf("10 downloads: %d lookups, %d bytes read", lookups, read)
+	}
+
+	// ranges seek in files of their own
+	download(3, "bytes=0-9")
+	if lookups, read := gfs.lookups.Load(), gfs.read.Load(); lookups != 3 || read != 30 {
+		t.Errorf("3 ranges: %d lookups, %d bytes read", lookups, read)
+	}
+}
+
+func TestCoalesceAfterCanceledCaller(t *testing.T) {
+
+	s, _ := newTestServer(t, testConfig())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	go s.coalesce(ctx, "a.txt", func() (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	<-started
+
+	done := make(chan interface{})
+	go func() {
+		value, _ := s.coalesce(context.Background(), "a.txt", func() (interface{}, error) {
+			return "own read", nil
+		})
+		done <- value
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	// the canceled first caller doesn't fail the waiting one
+	if value := <-done; value != "own read" {
+		t.Errorf("got %v", value)
+	}
+}
@@ -2,195 +2,1397 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"mime"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"gopkg.in/yaml.v2"
 	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
 )
 
-// file path and content
-type gridfile struct {
-	Path string
-	Data bytes.Buffer
+// session, mounts, logger and config shared by the handlers
+// Conf may change on SIGHUP, so request handlers read it through conf()
+type server struct {
+	Stores         storeOpener
+	Mounts         []*mount
+	Logger         *log.Logger
+	Limiter        *rateLimiter
+	Slots          chan struct{}
+	TrustedProxies []*net.IPNet
+	Metrics        *metrics
+	Tracer         *tracer
+	Flights        flightGroup
+	Types          *typeCache
+	Runtime        runtimeStats
+	Stats          statsCache
+	Conf           config
+	confLock       sync.RWMutex
+	logfile        *os.File
 }
 
-// make gridfs, logger and config globally accessible
-type gogridfs struct {
-	GFS    *mgo.GridFS
-	Logger *log.Logger
-	Conf   config
+// build the server for a loaded config on the stores of its mounts
+// mongodb connections of mounts are dialed by the stores when they are opened
+func newServer(conf config, stores storeOpener, logger *log.Logger) (s *server, err error) {
+
+	s = &server{Stores: stores, Logger: logger}
+	s.setConf(conf)
+	s.Runtime.started = time.Now()
+
+	// get gridfs of every mount
+	for _, mc := range mountConfigs(conf) {
+		if prefix, trimmed := bucketName(mc.GridFSCollection); trimmed {
+			s.warn("gridfscollection", mc.GridFSCollection, "names a collection of the bucket, using", prefix)
+			mc.GridFSCollection = prefix
+		}
+		m := &mount{mountConfig: mc, srv: s}
+		m.GFS, m.WriteGFS, err = stores.open(mc)
+		if err != nil {
+			return nil, fmt.Errorf("mount %s: %w", mc.HandlePath, err)
+		}
+		s.Mounts = append(s.Mounts, m)
+	}
+
+	// addresses allowed to forward client addresses, validated already
+	s.TrustedProxies, _ = parseProxies(conf.TrustedProxies)
+
+	// limit requests per client
+	if conf.RateLimitRPS > 0 {
+		s.Limiter = newRateLimiter(conf.RateLimitRPS, conf.RateLimitBurst)
+		go s.Limiter.cleanupLoop(time.Minute)
+	}
+
+	// bound concurrent downloads
+	if conf.MaxConcurrent > 0 {
+		s.Slots = make(chan struct{}, conf.MaxConcurrent)
+	}
+
+	// collect metrics if they are exposed
+	if conf.MetricsPath != "" {
+		s.Metrics = newMetrics()
+	}
+
+	// cache sniffed content types unless disabled
+	if conf.TypeCacheSize >= 0 {
+		size, ttl := conf.TypeCacheSize, conf.TypeCacheTTL
+		if size == 0 {
+			size = defaultTypeCacheSize
+		}
+		if ttl <= 0 {
+			ttl = defaultTypeCacheTTL
+		}
+		s.Types = newTypeCache(size, time.Duration(ttl)*time.Second)
+	}
+
+	// export spans if there is a collector
+	if conf.TracingEndpoint != "" {
+		s.Tracer = newTracer(conf.TracingEndpoint)
+		go s.Tracer.flushLoop(logger, 5*time.Second)
+	}
+
+	return
+}
+
+// register the handlers of all mounts and the optional endpoints
+func (s *server) routes() http.Handler {
+
+	conf := s.conf()
+	mux := http.NewServeMux()
+
+	for _, m := range s.Mounts {
+		mux.HandleFunc(m.HandlePath, s.accessLog(m.fileHandler))
+		for _, pattern := range m.PathPatterns {
+			mux.HandleFunc(pattern, s.accessLog(m.fileHandler))
+		}
+		if m.MetaPath != "" {
+			mux.HandleFunc(m.MetaPath, s.accessLog(m.metaHandler))
+		}
+		if m.ListPath != "" {
+			mux.HandleFunc(m.ListPath, s.accessLog(m.listHandler))
+		}
+		if m.ZipPath != "" {
+			mux.HandleFunc(m.ZipPath, s.accessLog(m.zipHandler))
+		}
+	}
+	if conf.HealthPath != "" {
+		mux.HandleFunc(conf.HealthPath, s.accessLog(s.healthHandler))
+	}
+	if conf.MetricsPath != "" {
+		mux.HandleFunc(conf.MetricsPath, s.Metrics.handler)
+	}
+	if conf.VersionPath != "" {
+		mux.HandleFunc(conf.VersionPath, s.accessLog(s.versionHandler))
+	}
+	if conf.StatsPath != "" {
+		mux.HandleFunc(conf.StatsPath, s.accessLog(s.statsHandler))
+	}
+	if conf.RuntimePath != "" {
+		mux.HandleFunc(conf.RuntimePath, s.accessLog(s.runtimeHandler))
+	}
+
+	if conf.StripPrefix != "" {
+		return stripPrefix(conf.StripPrefix, mux)
+	}
+
+	return mux
+}
+
+// remove the prefix from request paths starting with it
+// only whole segments match, /assets strips /assets/logo.png but not /assetsx/logo.png,
+// other requests are passed on unchanged
+func stripPrefix(prefix string, next http.Handler) http.Handler {
+
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		path := r.URL.Path
+		if prefix != "" && (path == prefix || strings.HasPrefix(path, prefix+"/")) {
+			path = path[len(prefix):]
+			if path == "" {
+				path = "/"
+			}
+			r2 := r.Clone(context.WithValue(r.Context(), originalPathKey{}, r.URL.Path))
+			r2.URL.Path = path
+			r2.URL.RawPath = ""
+			r = r2
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// context key of the request path before stripprefix cut it
+type originalPathKey struct{}
+
+// path the client requested, before stripprefix
+// signed urls are handed out for it
+func originalPath(r *http.Request) string {
+
+	if path, ok := r.Context().Value(originalPathKey{}).(string); ok {
+		return path
+	}
+
+	return r.URL.Path
 }
 
-var ggfs gogridfs
+// protocols of the web server, nil for the defaults
+// http/2 is negotiated over tls, h2c allows it in cleartext behind a proxy
+func serverProtocols(conf config) *http.Protocols {
 
-// config options to unmarshaled from json
+	if !conf.H2C {
+		return nil
+	}
+
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+
+	return protocols
+}
+
+// config options to unmarshaled from json or yaml
 type config struct {
-	Servers          []string
-	Logfile          string
-	Database         string
-	GridFSCollection string
-	Field            string // _id, filename
-	Listen           string
-	HandlePath       string
-	Debug            bool
-	Mode             string
+	Servers            []string          `json:"servers" yaml:"servers"`
+	MongoURI           string            `json:"mongouri" yaml:"mongouri"` // mongodb:// connection string, replaces servers
+	MongoUser          string            `json:"mongouser" yaml:"mongouser"`
+	MongoPassword      string            `json:"mongopassword" yaml:"mongopassword"`
+	AuthDatabase       string            `json:"authdatabase" yaml:"authdatabase"` // database of the mongo user, default admin
+	MongoTLS           bool              `json:"mongotls" yaml:"mongotls"`
+	MongoCAFile        string            `json:"mongocafile" yaml:"mongocafile"`
+	MongoInsecure      bool              `json:"mongoinsecure" yaml:"mongoinsecure"` // skip verifying the mongodb certificates
+	Logfile            string            `json:"logfile" yaml:"logfile"`
+	Database           string            `json:"database" yaml:"database"`
+	GridFSCollection   string            `json:"gridfscollection" yaml:"gridfscollection"`
+	Field              string            `json:"field" yaml:"field"`             // _id, filename, metadata.<key>
+	Listen             string            `json:"listen" yaml:"listen"`           // tcp address or unix:<socket path>
+	Listeners          []string          `json:"listeners" yaml:"listeners"`     // several addresses like listen, replaces it
+	AdminListen        string            `json:"adminlisten" yaml:"adminlisten"` // address of the admin listener
+	Profiling          bool              `json:"profiling" yaml:"profiling"`     // serve pprof on the admin listener
+	HandlePath         string            `json:"handlepath" yaml:"handlepath"`
+	MetaPath           string            `json:"metapath" yaml:"metapath"`
+	ListPath           string            `json:"listpath" yaml:"listpath"`
+	ZipPath            string            `json:"zippath" yaml:"zippath"`                     // zip archives of several files, disabled if empty
+	PathPatterns       []string          `json:"pathpatterns" yaml:"pathpatterns"`           // further routes like "/img/{path...}" serving files named by path
+	StripPrefix        string            `json:"stripprefix" yaml:"stripprefix"`             // removed from request paths before routing
+	PathPrefix         string            `json:"pathprefix" yaml:"pathprefix"`               // prepended to requested filenames
+	AllowedExtensions  []string          `json:"allowedextensions" yaml:"allowedextensions"` // served extensions like ".png", empty allows all
+	AllowedTypes       []string          `json:"allowedtypes" yaml:"allowedtypes"`           // served content types like "image/*", empty allows all
+	IndexFile          string            `json:"indexfile" yaml:"indexfile"`
+	NotFoundFile       string            `json:"notfoundfile" yaml:"notfoundfile"`       // served in place of missing files
+	NotFoundStatus     int               `json:"notfoundstatus" yaml:"notfoundstatus"`   // status of the notfoundfile, default 404
+	Disposition        string            `json:"disposition" yaml:"disposition"`         // attachment, inline, none
+	DispositionPath    bool              `json:"dispositionpath" yaml:"dispositionpath"` // keep the directories in the offered filename
+	CacheControl       string            `json:"cachecontrol" yaml:"cachecontrol"`
+	CacheControlByType map[string]string `json:"cachecontrolbytype" yaml:"cachecontrolbytype"` // content type or "type/*" => Cache-Control
+	ExtraHeaders       map[string]string `json:"extraheaders" yaml:"extraheaders"`             // added to every file response
+	HealthPath         string            `json:"healthpath" yaml:"healthpath"`
+	ShutdownTimeout    int               `json:"shutdowntimeout" yaml:"shutdowntimeout"` // seconds to wait for in-flight requests
+	TLSCert            string            `json:"tlscert" yaml:"tlscert"`
+	TLSKey             string            `json:"tlskey" yaml:"tlskey"`
+	TLSRedirectListen  string            `json:"tlsredirectlisten" yaml:"tlsredirectlisten"`
+	H2C                bool              `json:"h2c" yaml:"h2c"` // accept http/2 without tls
+	AuthUser           string            `json:"authuser" yaml:"authuser"`
+	AuthPass           string            `json:"authpass" yaml:"authpass"`
+	SigningSecret      string            `json:"signingsecret" yaml:"signingsecret"`     // secret of signed urls, signing is disabled if empty
+	CORSAllowOrigin    []string          `json:"corsalloworigin" yaml:"corsalloworigin"` // "*" or a list of origins
+	RateLimitRPS       float64           `json:"ratelimitrps" yaml:"ratelimitrps"`       // requests per second and client, 0 disables
+	RateLimitBurst     int               `json:"ratelimitburst" yaml:"ratelimitburst"`
+	MaxConcurrent      int               `json:"maxconcurrent" yaml:"maxconcurrent"`         // concurrent downloads, 0 means unlimited
+	MaxConcurrentWait  int               `json:"maxconcurrentwait" yaml:"maxconcurrentwait"` // milliseconds to wait for a free download slot
+	MaxBytesPerSecond  int64             `json:"maxbytespersecond" yaml:"maxbytespersecond"` // download speed per request, 0 means unlimited
+	TrustedProxies     []string          `json:"trustedproxies" yaml:"trustedproxies"`       // proxies allowed to set X-Forwarded-For
+	Mounts             []mountConfig     `json:"mounts" yaml:"mounts"`
+	AllowedDatabases   []string          `json:"alloweddatabases" yaml:"alloweddatabases"` // selectable with the X-Database header
+	MaxRetries         int               `json:"maxretries" yaml:"maxretries"`             // retries of failed lookups, default 1, -1 disables
+	RetryBackoff       int               `json:"retrybackoff" yaml:"retrybackoff"`         // milliseconds before the first retry, doubled on each one
+	BufferMaxBytes     int64             `json:"buffermaxbytes" yaml:"buffermaxbytes"`     // compressed in memory up to this size
+	ReadBufferSize     int               `json:"readbuffersize" yaml:"readbuffersize"`     // bytes read from gridfs at once, default 32KB
+	VerifyMD5          bool              `json:"verifymd5" yaml:"verifymd5"`               // check streamed files against their stored md5
+	ChecksumHeader     string            `json:"checksumheader" yaml:"checksumheader"`     // digest, content-md5, none if empty
+	LogFormat          string            `json:"logformat" yaml:"logformat"`               // text, json
+	LogExcludePaths    []string          `json:"logexcludepaths" yaml:"logexcludepaths"`   // path patterns like "/healthz" not access logged
+	LogSampleRate      float64           `json:"logsamplerate" yaml:"logsamplerate"`       // share of successful requests logged, 0 logs all
+	TracingEndpoint    string            `json:"tracingendpoint" yaml:"tracingendpoint"`   // OTLP/HTTP traces url, disabled if empty
+	PlainErrors        bool              `json:"plainerrors" yaml:"plainerrors"`
+	MetricsPath        string            `json:"metricspath" yaml:"metricspath"`
+	StatsPath          string            `json:"statspath" yaml:"statspath"`
+	StatsTTL           int               `json:"statsttl" yaml:"statsttl"`           // seconds storage statistics are cached, default 60
+	TypeCacheSize      int               `json:"typecachesize" yaml:"typecachesize"` // sniffed content types cached, default 1024, -1 disables
+	TypeCacheTTL       int               `json:"typecachettl" yaml:"typecachettl"`   // seconds sniffed content types are cached, default 300
+	VersionPath        string            `json:"versionpath" yaml:"versionpath"`
+	RuntimePath        string            `json:"runtimepath" yaml:"runtimepath"` // uptime and request counters as JSON, disabled if empty
+	ReadTimeout        int               `json:"readtimeout" yaml:"readtimeout"` // seconds, 0 means no timeout
+	WriteTimeout       int               `json:"writetimeout" yaml:"writetimeout"`
+	IdleTimeout        int               `json:"idletimeout" yaml:"idletimeout"`
+	StartupTimeout     int               `json:"startuptimeout" yaml:"startuptimeout"`     // seconds to wait for mongodb at startup
+	MongoTimeout       int               `json:"mongotimeout" yaml:"mongotimeout"`         // seconds to wait for gridfs lookups and every read of a chunk
+	Maintenance        bool              `json:"maintenance" yaml:"maintenance"`           // answer file requests with 503
+	MaintenanceRetry   int               `json:"maintenanceretry" yaml:"maintenanceretry"` // seconds, default 60
+	LogLevel           string            `json:"loglevel" yaml:"loglevel"`                 // debug, info, warn or error
+	Debug              bool              `json:"debug" yaml:"debug"`
+	Mode               string            `json:"mode" yaml:"mode"`
+	ReadPreference     string            `json:"readpreference" yaml:"readpreference"` // primary, primaryPreferred, secondary, secondaryPreferred, nearest
+	StrongWrites       bool              `json:"strongwrites" yaml:"strongwrites"`     // uploads and deletes on the primary whatever the mode
+	PoolLimit          int               `json:"poollimit" yaml:"poollimit"`           // sockets per mongodb server, 0 keeps the mgo default
+	Compress           bool              `json:"compress" yaml:"compress"`
+	EnableImageResize  bool              `json:"enableimageresize" yaml:"enableimageresize"`
+	MaxUploadBytes     int64             `json:"maxuploadbytes" yaml:"maxuploadbytes"`     // default 64MB, negative means unlimited
+	OverwriteReplace   bool              `json:"overwritereplace" yaml:"overwritereplace"` // remove older versions after uploads
+	AllowUpload        bool              `json:"allowupload" yaml:"allowupload"`
+	AllowDelete        bool              `json:"allowdelete" yaml:"allowdelete"`
 }
 
-// load config from json file
-func loadConfig(file string) (err error) {
+// load config from json or yaml file
+// environment variables take precedence over the file
+func loadConfig(file string) (conf config, err error) {
 
 	b_file, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		err = fmt.Errorf("config file %s not found", file)
+		return
+	} else if err != nil {
+		err = fmt.Errorf("unable to read config file %s: %w", file, err)
+		return
+	}
+
+	// yaml by extension, json by default
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b_file, &conf)
+		if err != nil {
+			err = fmt.Errorf("invalid YAML in config file %s: %w", file, err)
+			return
+		}
+	default:
+		err = json.Unmarshal(b_file, &conf)
+		if err != nil {
+			err = jsonError(file, b_file, err)
+			return
+		}
+	}
+
+	err = applyEnv(&conf)
 	if err != nil {
 		return
 	}
 
-	err = json.Unmarshal(b_file, &ggfs.Conf)
+	// the database of the connection string is the default one
+	if conf.MongoURI != "" && conf.Database == "" {
+		var info *mgo.DialInfo
+		info, err = mgo.ParseURL(conf.MongoURI)
+		if err != nil {
+			err = fmt.Errorf("invalid mongouri: %w", err)
+			return
+		}
+		conf.Database = info.Database
+	}
 
 	return
 }
 
-// fetch file from gridfs
-func getFile(value string, field string) (file bytes.Buffer, filename string, err error) {
+// describe a json error of the config file with the line and column it occurred in
+func jsonError(file string, data []byte, err error) error {
 
-	var gfsFile *mgo.GridFile
-	// open gridfile where value is the filename in GridFS
-	if field == "_id" {
-		gfsFile, err = ggfs.GFS.OpenId(value)
-	} else {
-		gfsFile, err = ggfs.GFS.Open(value)
+	var offset int64
+	switch jerr := err.(type) {
+	case *json.SyntaxError:
+		offset = jerr.Offset
+	case *json.UnmarshalTypeError:
+		offset = jerr.Offset
+	default:
+		return fmt.Errorf("invalid JSON in config file %s: %w", file, err)
 	}
 
+	// the offset points behind the offending character
+	position := offset - 1
+	if position < 0 {
+		position = 0
+	}
+	if position > int64(len(data)) {
+		position = int64(len(data))
+	}
+	line := 1 + bytes.Count(data[:position], []byte("\n"))
+	column := position - int64(bytes.LastIndexByte(data[:position], '\n'))
+
+	return fmt.Errorf("invalid JSON in config file %s at line %d, column %d (offset %d): %w", file, line, column, offset, err)
+}
+
+// open the log writer, stdout if no log file is configured
+// logfile is nil for stdout
+func openLog(file string) (writer io.Writer, logfile *os.File, err error) {
+
+	if file == "" {
+		writer = os.Stdout
+		return
+	}
+
+	logfile, err = os.OpenFile(file, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0750)
 	if err != nil {
 		return
 	}
+	writer = logfile
 
-	filename = gfsFile.Name()
+	return
+}
 
-	// read file into buffer
-	for {
-		buffer := make([]byte, 4096)
-		bytes_r, err := gfsFile.Read(buffer)
+// determine mode
+// Strong (safe) => 2
+// Monotonic (fast) => 1
+// Eventual (faster) => 0
+// default => 2
+func parseMode(logger *log.Logger, name string) (mode mgo.Mode) {
 
-		if bytes_r > 0 {
-			file.Write(buffer[:bytes_r])
+	mode = mgo.Strong
+	if strings.ToLower(name) == "monotonic" {
+		logger.Println("mgo connection mode: monotonic")
+		mode = mgo.Monotonic
+	} else if strings.ToLower(name) == "eventual" {
+		logger.Println("mgo connection mode: eventual")
+		mode = mgo.Eventual
+	}
+
+	return
+}
+
+// read preferences of a replica set
+// they take precedence over the mode when configured
+var readPreferences = map[string]mgo.Mode{
+	"primary":            mgo.Primary,
+	"primarypreferred":   mgo.PrimaryPreferred,
+	"secondary":          mgo.Secondary,
+	"secondarypreferred": mgo.SecondaryPreferred,
+	"nearest":            mgo.Nearest,
+}
+
+// determine the session mode from the read preference or the mode
+func sessionMode(logger *log.Logger, conf config) mgo.Mode {
+
+	if pref, ok := readPreferences[strings.ToLower(conf.ReadPreference)]; ok {
+		logger.Println("mgo read preference:", conf.ReadPreference)
+		return pref
+	}
+
+	return parseMode(logger, conf.Mode)
+}
+
+// open file from gridfs
+// failed lookups are retried on a refreshed session, e.g. after a mongodb restart
+// the lookup is abandoned with the context's error once it is done
+func (s *server) getFile(ctx context.Context, gfs gridStore, value string, field string, version int) (gfsFile gridFile, err error) {
+
+	ctx, sp := s.Tracer.start(ctx, "gridfs.open", spanKindClient)
+	sp.set("db.system", "mongodb")
+	sp.set("gridfs.field", field)
+	sp.set("gridfs.value", value)
+	defer func() {
+		sp.set("gridfs.found", err == nil)
+		if err == mgo.ErrNotFound {
+			sp.end(nil)
+		} else {
+			sp.end(err)
 		}
+	}()
 
-		if err != nil {
-			break
+	conf := s.conf()
+	retries := conf.MaxRetries
+	if retries == 0 {
+		retries = 1
+	}
+	backoff := time.Duration(conf.RetryBackoff) * time.Millisecond
+	if backoff <= 0 {
+		backoff = 100 * time.Millisecond
+	}
+
+	for attempt := 0; ; attempt++ {
+		gfsFile, err = openFile(ctx, gfs, value, field, version)
+		if err == nil || err == mgo.ErrNotFound || err == ctx.Err() {
+			return
 		}
+		s.mongoError()
+		if attempt >= retries {
+			return
+		}
+
+		s.warn(fmt.Sprintf("lookup of %s failed: %s, reconnecting in %s", value, err, backoff))
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+		if session := sessionOf(gfs); session != nil {
+			session.Refresh()
+		}
+	}
+}
+
+// open file from gridfs once
+// version selects among files sharing a filename, -1 is the newest
+// mgo can't be interrupted, so the lookup runs in the background
+// and a file opened after the context is done gets closed
+func openFile(ctx context.Context, gfs gridStore, value string, field string, version int) (gfsFile gridFile, err error) {
+
+	type result struct {
+		gfsFile gridFile
+		err     error
+	}
+	done := make(chan result, 1)
+
+	go func() {
+		var res result
+		// open gridfile where value is the filename, the _id or a metadata value in GridFS
+		if field == "_id" {
+			res.gfsFile, res.err = openId(gfs, value)
+		} else if isMetaField(field) {
+			res.gfsFile, res.err = openByMeta(gfs, field, value)
+		} else if version != -1 {
+			res.gfsFile, res.err = openVersion(gfs, value, version)
+		} else {
+			res.gfsFile, res.err = gfs.Open(value)
+		}
+		done <- res
+	}()
+
+	select {
+	case res := <-done:
+		return res.gfsFile, res.err
+	case <-ctx.Done():
+		go func() {
+			if res := <-done; res.err == nil {
+				res.gfsFile.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// check whether the field names a metadata key like "metadata.sku"
+func isMetaField(field string) bool {
+	return strings.HasPrefix(field, "metadata.") && len(field) > len("metadata.")
+}
+
+// ids a requested _id may stand for, an ObjectId first for 24 hex digits
+// files uploaded with an _id of their own have a string one
+func idValues(value string) []interface{} {
+
+	if bson.IsObjectIdHex(value) {
+		return []interface{}{bson.ObjectIdHex(value), value}
 	}
 
-	// non EOF error are to be handled
-	if err != io.EOF {
+	return []interface{}{value}
+}
+
+// open the file of a requested _id
+func openId(gfs gridStore, value string) (gfsFile gridFile, err error) {
+
+	for _, id := range idValues(value) {
+		gfsFile, err = gfs.OpenId(id)
+		if err != mgo.ErrNotFound {
+			return
+		}
+	}
+
+	return
+}
+
+// open the newest file whose metadata field matches the value
+func openByMeta(gfs gridStore, field string, value string) (gfsFile gridFile, err error) {
+
+	var doc struct {
+		Id interface{} `bson:"_id"`
+	}
+	err = gfs.Find(bson.M{field: value}).Sort("-uploadDate").Select(bson.M{"_id": 1}).One(&doc)
+	if err != nil {
 		return
 	}
 
-	// close gridfile
-	err = gfsFile.Close()
+	return gfs.OpenId(doc.Id)
+}
+
+// open a version of the files sharing a filename sorted by uploadDate
+// 0 is the oldest, negative versions count back from the newest
+func openVersion(gfs gridStore, name string, version int) (gfsFile gridFile, err error) {
+
+	order := "uploadDate"
+	skip := version
+	if version < 0 {
+		order = "-uploadDate"
+		skip = -version - 1
+	}
+
+	var doc struct {
+		Id interface{} `bson:"_id"`
+	}
+	err = gfs.Find(bson.M{"filename": name}).Sort(order).Skip(skip).Select(bson.M{"_id": 1}).One(&doc)
 	if err != nil {
 		return
 	}
 
+	return gfs.OpenId(doc.Id)
+}
+
+// context for the mongodb lookups of a request
+// limited to the configured mongo timeout, streaming content isn't
+func (s *server) mongoContext(r *http.Request) (ctx context.Context, cancel context.CancelFunc) {
+
+	timeout := s.conf().MongoTimeout
+	if timeout <= 0 {
+		return context.WithCancel(r.Context())
+	}
+
+	return context.WithTimeout(r.Context(), time.Duration(timeout)*time.Second)
+}
+
+// read of a chunk that took longer than the mongo timeout
+var errReadTimeout = errors.New("gridfs read timed out")
+
+// stream length bytes of file content from gridfs to the writer in chunks
+// so memory stays bounded regardless of the file size
+// files ending before length bytes are reported as io.ErrUnexpectedEOF
+// streaming stops with the context's error once it is done
+// the mongo timeout bounds every read, so a stuck one ends the stream with errReadTimeout
+// while a download that keeps making progress may take as long as the client needs
+func (s *server) streamFile(ctx context.Context, w io.Writer, gfsFile gridFile, length int64) (written int64, err error) {
+
+	conf := s.conf()
+	size := conf.ReadBufferSize
+	if size <= 0 {
+		size = 32 * 1024
+	}
+	buffer := make([]byte, size)
+	timeout := time.Duration(conf.MongoTimeout) * time.Second
+
+	for written < length {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		chunk := buffer
+		if remaining := length - written; remaining < int64(len(chunk)) {
+			chunk = chunk[:remaining]
+		}
+
+		bytes_r, read_err := readChunk(ctx, gfsFile, chunk, timeout)
+		if bytes_r > 0 {
+			bytes_w, write_err := w.Write(chunk[:bytes_r])
+			written += int64(bytes_w)
+			if write_err != nil {
+				return written, write_err
+			}
+		}
+
+		if read_err == io.EOF {
+			if written < length {
+				return written, io.ErrUnexpectedEOF
+			}
+			return written, nil
+		}
+		if read_err != nil {
+			return written, read_err
+		}
+	}
+
+	return
+}
+
+// read the next chunk of a file within the timeout, if there is one
+// mgo can't interrupt a read, one that times out is left to finish in the background
+// and the buffer must not be reused
+func readChunk(ctx context.Context, gfsFile gridFile, chunk []byte, timeout time.Duration) (n int, err error) {
+
+	if timeout <= 0 {
+		return gfsFile.Read(chunk)
+	}
+
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := gfsFile.Read(chunk)
+		done <- result{n, err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		return res.n, res.err
+	case <-timer.C:
+		return 0, errReadTimeout
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// audio and video types missing from minimal mime tables
+// players seek with many small range requests, knowing the type
+// spares reading the first chunk on every one of them for sniffing
+var mediaTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".mov":  "video/quicktime",
+	".webm": "video/webm",
+	".ogv":  "video/ogg",
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".ogg":  "audio/ogg",
+	".wav":  "audio/wav",
+}
+
+// content type of a gridfile known without reading it
+// stored metadata wins over the file extension
+func declaredType(gfsFile gridFile) string {
+
+	if ctype := gfsFile.ContentType(); ctype != "" {
+		return ctype
+	}
+
+	ext := strings.ToLower(filepath.Ext(gfsFile.Name()))
+	if ctype := mime.TypeByExtension(ext); ctype != "" {
+		return ctype
+	}
+
+	return mediaTypes[ext]
+}
+
+// determine the content type of a gridfile
+// stored metadata wins, then the file extension, then content sniffing
+func contentType(gfsFile gridFile) (ctype string, err error) {
+
+	ctype = declaredType(gfsFile)
+	if ctype != "" {
+		return
+	}
+
+	// empty files have nothing to sniff, DetectContentType would call them text
+	if gfsFile.Size() == 0 {
+		ctype = "application/octet-stream"
+		return
+	}
+
+	// sniff the first 512 bytes and rewind the file afterwards
+	buffer := make([]byte, 512)
+	bytes_r, err := io.ReadFull(gfsFile, buffer)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return
+	}
+	ctype = http.DetectContentType(buffer[:bytes_r])
+
+	_, err = gfsFile.Seek(0, io.SeekStart)
+
 	return
 }
 
-// handle HTTP requests
-func fileHandler(w http.ResponseWriter, r *http.Request) {
+// handle HTTP requests for files of the mount
+func (m *mount) fileHandler(w http.ResponseWriter, r *http.Request) {
+
+	conf := m.srv.conf()
+	setExtraHeaders(w, conf)
+
+	r, sp := m.srv.Tracer.startRequest(r, r.Method+" "+m.HandlePath)
+	defer sp.endRequest(w)
+
+	if !m.srv.checkRateLimit(w, r) {
+		return
+	}
+	if !m.srv.checkMaintenance(w, r, conf) {
+		return
+	}
+
+	// preflight requests come without credentials
+	// signed urls replace them
+	if handleCORS(w, r, conf) {
+		return
+	}
+	switch r.Method {
+	case "GET", "HEAD", "PUT", "POST", "DELETE":
+	default:
+		m.srv.methodNotAllowed(w, r, conf)
+		return
+	}
+	if signedRequest(r, conf) {
+		if !m.srv.checkSignature(w, r, conf) {
+			return
+		}
+	} else if !m.srv.checkAuth(w, r, conf) {
+		return
+	}
+
+	m, err := m.withDatabase(r)
+	if err != nil {
+		m.srv.writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m, err = m.withField(r)
+	if err != nil {
+		m.srv.writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	// cut handlepath from URL path
+	// cut handlepath from URL path or take the path of a matched pattern
 	// remainder will be the filename to fetch from GridFS
-	path := r.URL.Path[len(ggfs.Conf.HandlePath):]
+	path, err := cleanPath(m.requestPath(r))
+	if err != nil {
+		m.srv.writeError(w, r, "invalid path", http.StatusBadRequest)
+		return
+	}
+	if path == "" && r.Method != "POST" && (conf.IndexFile == "" || r.Method == "PUT" || r.Method == "DELETE") {
+		m.srv.writeError(w, r, "no file name given", http.StatusBadRequest)
+		return
+	}
 
 	// print requested path when debugging
-	if ggfs.Conf.Debug == true {
-		ggfs.Logger.Println(path)
+	m.srv.debug(path)
+
+	// writes are disabled unless explicitly allowed
+	if r.Method == "PUT" {
+		if !conf.AllowUpload {
+			m.srv.methodNotAllowed(w, r, conf)
+			return
+		}
+		m.uploadHandler(w, r, m.filename(path))
+		return
+	}
+	if r.Method == "POST" {
+		if !conf.AllowUpload {
+			m.srv.methodNotAllowed(w, r, conf)
+			return
+		}
+		m.formUploadHandler(w, r, m.filename(path))
+		return
+	}
+	if r.Method == "DELETE" {
+		if !conf.AllowDelete {
+			m.srv.methodNotAllowed(w, r, conf)
+			return
+		}
+		m.deleteHandler(w, r, m.filename(path))
+		return
 	}
 
-	data, filename, err := getFile(path, ggfs.Conf.Field)
+	// protect mongodb from too many simultaneous reads
+	if !m.srv.acquireSlot(w, r) {
+		return
+	}
+	defer m.srv.releaseSlot()
 
-	// build the file struct
-	file := gridfile{Path: path, Data: data}
+	// ?version=N picks among files sharing the filename, the newest by default
+	version, err := queryInt(r, "version", -1)
 	if err != nil {
-		ggfs.Logger.Println(err)
+		m.srv.writeError(w, r, "invalid version", http.StatusBadRequest)
+		return
+	}
+
+	// the mongo timeout bounds the lookups and every read of the content,
+	// which is sent for as long as the client keeps reading
+	ctx, cancel := m.srv.mongoContext(r)
+	defer cancel()
+
+	// directory style requests are served the index file below them
+	// and fall back to the exact path
+	var gfsFile gridFile
+	err = mgo.ErrNotFound
+	if conf.IndexFile != "" && (path == "" || strings.HasSuffix(path, "/")) {
+		gfsFile, err = m.lookupFile(ctx, r, m.filename(path)+conf.IndexFile, m.Field, version)
+	}
+	if err == mgo.ErrNotFound && path != "" {
+		gfsFile, err = m.lookupFile(ctx, r, m.filename(path), m.Field, version)
+	}
+
+	// missing files are answered with the fallback file if there is one
+	status := http.StatusOK
+	if err == mgo.ErrNotFound && conf.NotFoundFile != "" {
+		gfsFile, err = m.lookupFile(ctx, r, conf.NotFoundFile, "filename", -1)
+		status = conf.NotFoundStatus
+		if status == 0 {
+			status = http.StatusNotFound
+		}
+	}
+	if err == mgo.ErrNotFound {
+		m.srv.writeError(w, r, "file not found", http.StatusNotFound)
+		return
+	} else if err != nil && r.Context().Err() == context.Canceled {
+		m.srv.logCanceled(w, r)
+		return
+	} else if err == context.DeadlineExceeded {
+		m.srv.logError(w, fmt.Errorf("lookup of %s timed out", path))
+		m.srv.writeError(w, r, "gateway timeout", http.StatusGatewayTimeout)
+		return
+	} else if err != nil {
+		m.srv.logError(w, err)
+		m.srv.writeError(w, r, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer func() {
+		if err := gfsFile.Close(); err != nil {
+			m.srv.logError(w, err)
+		}
+	}()
+	sp.set("gridfs.file.size", gfsFile.Size())
+
+	// files stored compressed carry their encoding, empty ones are sent as they are
+	encoding := ""
+	if gfsFile.Size() > 0 {
+		encoding = storedEncoding(gfsFile)
+	}
+
+	ctype, err := m.storedType(gfsFile, encoding)
+	if err != nil {
+		m.srv.logError(w, err)
+		m.srv.writeError(w, r, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// mounts may be restricted to some extensions or content types,
+	// checked before anything about the file is answered
+	if !m.allowed(gfsFile.Name(), ctype) {
+		m.srv.writeError(w, r, "file type not allowed", http.StatusForbidden)
+		return
+	}
+
+	// fallback files answered with an error status are neither cached nor offered as downloads
+	errorPage := status != http.StatusOK
+	if !errorPage {
+		setCacheControl(w, conf, ctype)
 	}
+
+	// resize images if requested, files stored compressed are served as they are
+	if conf.EnableImageResize && status == http.StatusOK && encoding == "" {
+		width, height, resize, err := resizeParams(r)
+		if err != nil {
+			m.srv.writeError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if resize && m.resizeHandler(w, r, gfsFile, ctype, width, height) {
+			return
+		}
+	}
+
+	// unchanged files are answered with 304 Not Modified
+	// If-Modified-Since only counts without If-None-Match
+	// fallback files answered with an error status never are
+	etag := fileETag(gfsFile.MD5())
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	modtime := gfsFile.UploadDate()
+	w.Header().Set("Last-Modified", modtime.UTC().Format(http.TimeFormat))
+	if header := r.Header.Get("If-None-Match"); header != "" && status == http.StatusOK {
+		if etagMatch(header, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	} else if header := r.Header.Get("If-Modified-Since"); header != "" && status == http.StatusOK && notModifiedSince(header, modtime) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// files stored compressed are passed through to clients accepting
+	// their encoding, gzipped ones are decompressed for all others
+	encoded := encoding != "" && acceptsEncoding(r, encoding)
+	decompress := encoding == "gzip" && !encoded
+	if encoding != "" && !encoded && !decompress {
+		w.Header().Add("Vary", "Accept-Encoding")
+		m.srv.writeError(w, r, encoding+" encoding not accepted", http.StatusNotAcceptable)
+		return
+	}
+
+	// serve the requested byte ranges, several of them as multipart/byteranges
+	// invalid and too many ranges fall back to the whole file,
+	// as do ranges of decompressed files whose size is unknown,
+	// several ranges of files sent in their stored encoding, which would label
+	// the whole multipart body as encoded, and ranges of files changed since
+	// the If-Range validator
+	size := gfsFile.Size()
+	length := size
+	var multi []byteRange
+	var boundary string
+	header := r.Header.Get("Range")
+	if cond := r.Header.Get("If-Range"); cond != "" && !ifRangeMatch(cond, etag, modtime) {
+		header = ""
+	}
+	if header != "" && !decompress && status == http.StatusOK {
+		ranges, err := parseRange(header, size)
+		if err == errRangeNotSatisfiable {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			m.srv.writeError(w, r, "requested range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if err == nil && len(ranges) == 1 {
+			// seeking only fetches the chunk holding the start offset,
+			// so seeks to the end of large videos don't read the whole file
+			if _, err = gfsFile.Seek(ranges[0].Start, io.SeekStart); err != nil {
+				m.srv.logError(w, err)
+				m.srv.writeError(w, r, "internal server error", http.StatusInternalServerError)
+				return
+			}
+			status = http.StatusPartialContent
+			length = ranges[0].Length
+			w.Header().Set("Content-Range", ranges[0].contentRange(size))
+		} else if err == nil && len(ranges) <= maxRanges && !encoded {
+			status = http.StatusPartialContent
+			multi = ranges
+			boundary = multipart.NewWriter(nil).Boundary()
+			length = multipartLength(multi, ctype, size, boundary)
+		}
+	}
+
+	if multi != nil {
+		w.Header().Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+	} else {
+		w.Header().Set("Content-Type", ctype)
+	}
+	if !decompress {
+		w.Header().Set("Accept-Ranges", "bytes")
+	}
+
+	// compress whole responses of compressible types if the client accepts gzip
+	// files up to buffermaxbytes are compressed in memory for an exact Content-Length,
+	// larger ones are compressed while streaming and sent chunked
+	// a throttle applies to the bytes sent, after compression
+	var out io.Writer = w
+	if conf.MaxBytesPerSecond > 0 {
+		out = newThrottledWriter(r.Context(), w, conf.MaxBytesPerSecond)
+	}
+	body := out
+	var buffered []byte
+	var verifier *md5Writer
+	compress := encoding == "" && conf.Compress && isCompressible(ctype) && size > 0
+	if compress || encoding != "" {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	if encoded {
+		w.Header().Set("Content-Encoding", encoding)
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	} else if decompress {
+		if r.Method != "HEAD" {
+			gunzip := newGunzipWriter(out)
+			defer func() {
+				if err := gunzip.Close(); err != nil {
+					m.srv.logError(w, err)
+				}
+			}()
+			body = gunzip
+		}
+	} else if compress && status != http.StatusPartialContent && acceptsEncoding(r, "gzip") {
+		w.Header().Set("Content-Encoding", "gzip")
+		if conf.BufferMaxBytes > 0 && size <= conf.BufferMaxBytes {
+			buffered, verifier, err = m.srv.gzipFile(r.Context(), gfsFile, conf.VerifyMD5)
+			if err != nil {
+				m.srv.logError(w, err)
+				m.srv.writeError(w, r, "internal server error", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(buffered)))
+		} else if r.Method != "HEAD" {
+			gz := gzip.NewWriter(out)
+			defer func() {
+				if err := gz.Close(); err != nil {
+					m.srv.logError(w, err)
+				}
+			}()
+			body = gz
+		}
+	} else {
+		// the size stored in gridfs gives clients an exact Content-Length for
+		// progress bars, only compressed responses are sent chunked
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	}
+
+	// the stored md5 only covers whole files sent as they are stored
+	if status != http.StatusPartialContent && !decompress && (encoded || w.Header().Get("Content-Encoding") == "") {
+		setChecksum(w, conf.ChecksumHeader, gfsFile.MD5())
+	}
+
 	// Content-Disposition: attachment; filename="$filename"
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
-	// print buffer to response writer
-	fmt.Fprintf(w, "%s", file.Data.String())
+	if dtype := dispositionType(r, conf); dtype != "" && !errorPage {
+		w.Header().Set("Content-Disposition", contentDisposition(dtype, dispositionName(gfsFile.Name(), conf)))
+	}
+
+	w.WriteHeader(status)
+
+	// HEAD gets the headers only
+	if r.Method == "HEAD" {
+		return
+	}
+
+	// hash whole files to detect corruption
+	// the stored md5 of compressed files covers the compressed bytes
+	if conf.VerifyMD5 && status == http.StatusOK && gfsFile.MD5() != "" && buffered == nil {
+		verifier = newMD5Writer(body)
+		body = verifier
+	}
+
+	// stream gridfile to response writer
+	// headers are gone once the body has started, so errors can only be logged
+	if buffered != nil {
+		_, err = out.Write(buffered)
+	} else if multi != nil {
+		err = m.srv.streamRanges(r.Context(), body, gfsFile, multi, ctype, boundary)
+	} else {
+		_, err = m.srv.streamFile(r.Context(), body, gfsFile, length)
+	}
+	if err != nil && r.Context().Err() == context.Canceled {
+		m.srv.logCanceled(w, r)
+		return
+	} else if err != nil {
+		m.srv.logError(w, err)
+		return
+	}
+
+	if verifier != nil && !verifier.verify(gfsFile) {
+		m.srv.logError(w, fmt.Errorf("CORRUPTION: md5 of %s (%v) doesn't match the stored %s", gfsFile.Name(), gfsFile.Id(), gfsFile.MD5()))
+	}
+}
+
+// login failures, which retrying won't fix
+var errMongoAuth = errors.New("mongodb authentication failed")
+
+// connect to mongodb with the configured mode, credentials and pool limit
+func dialMongo(logger *log.Logger, conf config) (mgo_session *mgo.Session, err error) {
+
+	// concatenate mongodb servers to single string of comma seperated servers
+	// the connection string takes precedence
+	var servers string
+	for _, server := range conf.Servers {
+		servers += (server + ",")
+	}
+	if conf.MongoURI != "" {
+		servers = conf.MongoURI
+	}
+
+	mode := sessionMode(logger, conf)
+
+	if conf.MongoTLS {
+		mgo_session, err = dialMongoTLS(servers, conf)
+	} else {
+		mgo_session, err = mgo.Dial(servers)
+	}
+	if err != nil {
+		return
+	}
+	mgo_session.SetMode(mode, true)
+
+	// credentials of the config add to those of the connection string
+	if conf.MongoUser != "" {
+		source := conf.AuthDatabase
+		if source == "" {
+			source = "admin"
+		}
+		err = mgo_session.Login(&mgo.Credential{Username: conf.MongoUser, Password: conf.MongoPassword, Source: source})
+		if err != nil {
+			mgo_session.Close()
+			mgo_session = nil
+			err = fmt.Errorf("%w for user %s on %s: %s", errMongoAuth, conf.MongoUser, source, err)
+			return
+		}
+	}
+
+	// size the socket pool per server
+	if conf.PoolLimit > 0 {
+		mgo_session.SetPoolLimit(conf.PoolLimit)
+		logger.Println("mgo pool limit:", conf.PoolLimit)
+	} else {
+		logger.Println("mgo pool limit: default")
+	}
+
+	return
+}
+
+// connect like mgo.Dial, with every server connection wrapped in tls
+func dialMongoTLS(servers string, conf config) (mgo_session *mgo.Session, err error) {
+
+	tlsConfig, err := loadMongoTLSConfig(conf.MongoCAFile, conf.MongoInsecure)
+	if err != nil {
+		return
+	}
+
+	info, err := mgo.ParseURL(servers)
+	if err != nil {
+		return
+	}
+	info.Timeout = 10 * time.Second
+	info.DialServer = func(addr *mgo.ServerAddr) (net.Conn, error) {
+		return tls.DialWithDialer(&net.Dialer{Timeout: info.Timeout}, "tcp", addr.String(), tlsConfig)
+	}
+
+	mgo_session, err = mgo.DialWithInfo(info)
+	if err != nil {
+		return
+	}
+	mgo_session.SetSyncTimeout(time.Minute)
+	mgo_session.SetSocketTimeout(time.Minute)
+
+	return
 }
 
 func main() {
 
 	// get config file from command line args
-	var config_file = flag.String("config", "config.json", "Config file in JSON format")
+	var config_file = flag.String("config", "config.json", "Config file in JSON or YAML format")
+	var print_version = flag.Bool("version", false, "Print version information and exit")
+	var print_default_config = flag.Bool("print-default-config", false, "Print a commented YAML config with every field and its default and exit")
+	var print_config_schema = flag.Bool("print-config-schema", false, "Print a JSON schema of the config file and exit")
+	var check = flag.Bool("check", false, "Check the config file and the mongodb connection and exit")
+	var sign_path = flag.String("sign", "", "Print a signed URL for the path and query, e.g. /gridfs/file.png?w=100, and exit")
+	var sign_ttl = flag.Duration("ttl", time.Hour, "Validity of URLs signed with -sign")
+	var upload = flag.String("upload", "", "Upload the local file into GridFS, print its _id and exit")
+	var upload_mount = flag.String("mount", "", "Handle path of the mount -upload stores into, defaults to the first one")
+	var upload_name = flag.String("name", "", "Name of the file uploaded with -upload as in requests to the mount, defaults to its base name")
+	var upload_type = flag.String("content-type", "", "Content type of the file uploaded with -upload, defaults to the one of its extension")
 	flag.Parse()
 
-	// load config from JSON file
-	err := loadConfig(*config_file)
+	if *print_version {
+		fmt.Println(currentVersion())
+		return
+	}
+
+	if *print_default_config {
+		if err := printDefaultConfig(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *print_config_schema {
+		if err := printConfigSchema(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	// panic on errors before the log file is in place
+	if *check {
+		os.Exit(checkConfig(*config_file))
+	}
+
+	if *sign_path != "" {
+		os.Exit(signCommand(*config_file, *sign_path, *sign_ttl))
+	}
+
+	if *upload != "" {
+		os.Exit(uploadCommand(*config_file, *upload, *upload_mount, *upload_name, *upload_type))
+	}
+
+	// load config from JSON or YAML file
+	conf, err := loadConfig(*config_file)
+
+	// exit on errors before the log file is in place
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
 	// initialize log writer
-	var writer io.Writer
-	if ggfs.Conf.Logfile == "" {
-		writer = os.Stdout
-	} else {
-		writer, err = os.OpenFile(ggfs.Conf.Logfile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0750)
-		// panic on errors before the log file is in place
-		if err != nil {
-			panic(err)
-		}
+	writer, logfile, err := openLog(conf.Logfile)
+	// exit on errors before the log file is in place
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to open log file %s: %s\n", conf.Logfile, err)
+		os.Exit(1)
 	}
 
-	ggfs.Logger = log.New(writer, "", 5)
+	logger := log.New(writer, "", 5)
 
-	// concatenate mongodb servers to single string of comma seperated servers
-	var servers string
-	for _, server := range ggfs.Conf.Servers {
-		servers += (server + ",")
+	// die on invalid config before connecting to anything
+	err = validateConfig(conf)
+	if err != nil {
+		logger.Fatalln(err)
 	}
 
-	// determine mode
-	// Strong (safe) => 2
-	// Monotonic (fast) => 1
-	// Eventual (faster) => 0
-	// default => 2
-	mode := mgo.Strong
-	if strings.ToLower(ggfs.Conf.Mode) == "monotonic" {
-		ggfs.Logger.Println("mgo connection mode: monotonic")
-		mode = mgo.Monotonic
-	} else if strings.ToLower(ggfs.Conf.Mode) == "eventual" {
-		ggfs.Logger.Println("mgo connection mode: eventual")
-		mode = mgo.Eventual
+	// requests are answered with 503 until mongodb is connected
+	gate := newStartupHandler(conf.HealthPath)
+
+	// run webserver until SIGINT or SIGTERM
+	srv := &http.Server{
+		ReadTimeout:  time.Duration(conf.ReadTimeout) * time.Second,
+		WriteTimeout: time.Duration(conf.WriteTimeout) * time.Second,
+		IdleTimeout:  time.Duration(conf.IdleTimeout) * time.Second,
+		Handler:      gate,
+		Protocols:    serverProtocols(conf),
+	}
+	webservers := []*http.Server{srv}
+
+	// serve https if a certificate is configured
+	useTLS := conf.TLSCert != "" && conf.TLSKey != ""
+	if useTLS {
+		srv.TLSConfig, err = loadTLSConfig(conf.TLSCert, conf.TLSKey)
+		if err != nil {
+			logger.Fatalln("Unable to load TLS certificate and key:", err)
+		}
 	}
 
-	// die if no servers are configured
-	if servers == "" {
-		ggfs.Logger.Fatalln("No mongodb servers. Please adjust your config file.")
+	// open every listener before serving on any of them
+	var listeners []net.Listener
+	for _, address := range listenAddresses(conf) {
+		listener, err := listen(address)
+		if err != nil {
+			logger.Fatalln(err)
+		}
+		listeners = append(listeners, listener)
 	}
 
-	// connect to mongodb
-	mgo_session, err := mgo.Dial(servers)
-	mgo_session.SetMode(mode, true)
+	// shutting down closes the listeners and removes unix sockets
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			var err error
+			if useTLS {
+				err = srv.ServeTLS(listener, "", "")
+			} else {
+				err = srv.Serve(listener)
+			}
+			if err != http.ErrServerClosed {
+				logger.Fatalln(err)
+			}
+		}(listener)
+	}
+
+	// connect to mongodb, waiting up to startuptimeout for it to come up
+	mgo_session, err := waitForMongo(logger, conf)
 	if err != nil {
-		ggfs.Logger.Fatalln(err)
+		logger.Fatalln(err)
 	}
 	defer mgo_session.Close()
 
-	// get gridfs
-	ggfs.GFS = mgo_session.DB(ggfs.Conf.Database).GridFS(ggfs.Conf.GridFSCollection)
+	stores := newMongoStores(conf, mgo_session, logger)
+	defer stores.close()
+	s, err := newServer(conf, stores, logger)
+	if err != nil {
+		logger.Fatalln(err)
+	}
+	s.logfile = logfile
+
+	// redirect plain http to https
+	if useTLS && conf.TLSRedirectListen != "" {
+		redirect := &http.Server{Addr: conf.TLSRedirectListen, Handler: http.HandlerFunc(s.redirectHandler)}
+		webservers = append(webservers, redirect)
+		go func() {
+			err := redirect.ListenAndServe()
+			if err != http.ErrServerClosed {
+				s.Logger.Fatalln(err)
+			}
+		}()
+	}
+
+	// config and profiling are only served on the separate admin listener
+	if conf.AdminListen != "" {
+		listener, err := listen(conf.AdminListen)
+		if err != nil {
+			s.Logger.Fatalln(err)
+		}
+		admin := &http.Server{Handler: s.adminRoutes()}
+		webservers = append(webservers, admin)
+		go func() {
+			err := admin.Serve(listener)
+			if err != http.ErrServerClosed {
+				s.Logger.Fatalln(err)
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go s.shutdownOnSignal(done, webservers...)
+	go s.reloadOnSignal(*config_file)
+
+	gate.ready(s.routes())
+	s.info("ready")
+
+	// wait for in-flight requests before closing the mongodb session
+	<-done
+	s.info("closing mongodb session")
+}
+
+// shut the webservers down on SIGINT or SIGTERM
+// done is closed once in-flight requests are finished or the timeout is reached
+func (s *server) shutdownOnSignal(done chan struct{}, servers ...*http.Server) {
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+
+	timeout := time.Duration(s.conf().ShutdownTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	s.info(fmt.Sprintf("received %s, shutting down within %s", sig, timeout))
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, srv := range servers {
+		err := srv.Shutdown(ctx)
+		if err != nil {
+			s.warn("shutdown:", err)
+		}
+	}
+	s.info("webserver stopped")
+
+	if err := s.Tracer.flush(); err != nil {
+		s.warn(err)
+	}
 
-	// run webserver
-	http.HandleFunc(ggfs.Conf.HandlePath, fileHandler)
-	http.ListenAndServe(ggfs.Conf.Listen, nil)
+	close(done)
 }
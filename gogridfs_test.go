@@ -0,0 +1,652 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"labix.org/v2/mgo/bson"
+)
+
+// config of a single mount serving fs of the test database below /gridfs/
+func testConfig() config {
+	return config{
+		Database:         "test",
+		GridFSCollection: "fs",
+		Field:            "filename",
+		HandlePath:       "/gridfs/",
+	}
+}
+
+// server on in-memory stores and the store of its first mount
+func newTestServer(t testing.TB, conf config) (s *server, gfs *memStore) {
+
+	stores := newMemStores()
+	s, err := newServer(conf, stores, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mc := mountConfigs(conf)[0]
+
+	return s, stores.bucket(mc.Database, mc.GridFSCollection)
+}
+
+// response of the server's routes to a request
+func serve(s *server, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.routes().ServeHTTP(w, r)
+	return w
+}
+
+func TestServeFile(t *testing.T) {
+
+	s, gfs := newTestServer(t, testConfig())
+	file := gfs.put("docs/a.txt", "hello world", "text/plain", nil)
+
+	w := serve(s, httptest.NewRequest("GET", "/gridfs/docs/a.txt", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "hello world" {
+		t.Fatalf("got %d %q", w.Code, w.Body.String())
+	}
+	for name, want := range map[string]string{
+		"Content-Type":        "text/plain",
+		"Content-Length":      "11",
+		"ETag":                `"` + file.md5 + `"`,
+		"Content-Disposition": `attachment; filename="a.txt"`,
+		"Accept-Ranges":       "bytes",
+	} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+
+	w = serve(s, httptest.NewRequest("HEAD", "/gridfs/docs/a.txt", nil))
+	if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("Content-Length") != "11" {
+		t.Errorf("HEAD: got %d %q %s", w.Code, w.Body.String(), w.Header().Get("Content-Length"))
+	}
+}
+
+func TestServeMissingFile(t *testing.T) {
+
+	s, _ := newTestServer(t, testConfig())
+
+	w := serve(s, httptest.NewRequest("GET", "/gridfs/missing.txt", nil))
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), "file not found") {
+		t.Fatalf("got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestServeRanges(t *testing.T) {
+
+	s, gfs := newTestServer(t, testConfig())
+	gfs.put("a.txt", "0123456789", "text/plain", nil)
+
+	tests := []struct {
+		header string
+		status int
+		body   string
+		crange string
+	}{
+		{"bytes=2-4", http.StatusPartialContent, "234", "bytes 2-4/10"},
+		{"bytes=-3", http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{"bytes=8-", http.StatusPartialContent, "89", "bytes 8-9/10"},
+		{"bytes=20-30", http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+		{"lines=1-2", http.StatusOK, "0123456789", ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/gridfs/a.txt", nil)
+		r.Header.Set("Range", test.header)
+		w := serve(s, r)
+		if w.Code != test.status || w.Header().Get("Content-Range") != test.crange {
+			t.Errorf("%s: got %d %q", test.header, w.Code, w.Header().Get("Content-Range"))
+		}
+		if test.body != "" && w.Body.String() != test.body {
+			t.Errorf("%s: got body %q, want %q", test.header, w.Body.String(), test.body)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/gridfs/a.txt", nil)
+	r.Header.Set("Range", "bytes=0-1,5-6")
+	w := serve(s, r)
+	if w.Code != http.StatusPartialContent || !strings.HasPrefix(w.Header().Get("Content-Type"), "multipart/byteranges") {
+		t.Fatalf("multiple ranges: got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(w.Body.String(), "01") || !strings.Contains(w.Body.String(), "56") {
+		t.Errorf("multiple ranges: got %q", w.Body.String())
+	}
+}
+
+func TestMongoTimeoutSparesDownloads(t *testing.T) {
+
+	conf := testConfig()
+	conf.MongoTimeout = 1
+	conf.ReadBufferSize = 4
+	s, gfs := newTestServer(t, conf)
+
+	// reading the content takes longer than the timeout, the lookup doesn't
+	file := gfs.put("slow.txt", "0123456789ab", "text/plain", nil)
+	file.delay = 400 * time.Millisecond
+
+	w := serve(s, httptest.NewRequest("GET", "/gridfs/slow.txt", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789ab" {
+		t.Fatalf("got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestMongoTimeoutEndsStuckRead(t *testing.T) {
+
+	conf := testConfig()
+	conf.MongoTimeout = 1
+	s, gfs := newTestServer(t, conf)
+	gfs.put("stuck.txt", "content", "text/plain", nil).delay = 3 * time.Second
+
+	gfsFile, err := gfs.Open("stuck.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	written, err := s.streamFile(context.Background(), io.Discard, gfsFile, gfsFile.Size())
+	if err != errReadTimeout || written != 0 {
+		t.Errorf("got %d %v", written, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("waited %s for a stuck read", elapsed)
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+
+	s, gfs := newTestServer(t, testConfig())
+	gfs.put("a.txt", "secret content", "text/plain", nil)
+
+	for _, method := range []string{"PATCH", "OPTIONS", "TRACE", "PROPFIND", "PUT", "DELETE"} {
+		w := serve(s, httptest.NewRequest(method, "/gridfs/a.txt", nil))
+		if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, HEAD" || strings.Contains(w.Body.String(), "secret") {
+			t.Errorf("%s: got %d %q %q", method, w.Code, w.Header().Get("Allow"), w.Body.String())
+		}
+	}
+
+	// the list follows the config, preflights are still answered
+	conf := testConfig()
+	conf.AllowUpload = true
+	conf.CORSAllowOrigin = []string{"*"}
+	s, _ = newTestServer(t, conf)
+	if w := serve(s, httptest.NewRequest("PATCH", "/gridfs/a.txt", nil)); w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, HEAD, OPTIONS, PUT, POST" {
+		t.Errorf("PATCH: got %d %q", w.Code, w.Header().Get("Allow"))
+	}
+	r := httptest.NewRequest("OPTIONS", "/gridfs/a.txt", nil)
+	r.Header.Set("Origin", "https://example.com")
+	r.Header.Set("Access-Control-Request-Method", "PUT")
+	if w := serve(s, r); w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Methods") != "GET, HEAD, OPTIONS, PUT, POST" {
+		t.Errorf("preflight: got %d %q", w.Code, w.Header().Get("Access-Control-Allow-Methods"))
+	}
+}
+
+func TestNotFoundFile(t *testing.T) {
+
+	conf := testConfig()
+	conf.NotFoundFile = "404.html"
+	conf.CacheControl = "public, max-age=3600"
+	s, gfs := newTestServer(t, conf)
+	gfs.put("404.html", "<h1>gone</h1>", "text/html", nil)
+
+	w := serve(s, httptest.NewRequest("GET", "/gridfs/missing.html", nil))
+	if w.Code != http.StatusNotFound || w.Body.String() != "<h1>gone</h1>" {
+		t.Fatalf("got %d %q", w.Code, w.Body.String())
+	}
+	for _, name := range []string{"Cache-Control", "Content-Disposition"} {
+		if got := w.Header().Get(name); got != "" {
+			t.Errorf("%s: got %q on a 404", name, got)
+		}
+	}
+
+	// served as the page itself for spa routing
+	conf.NotFoundStatus = http.StatusOK
+	s, gfs = newTestServer(t, conf)
+	gfs.put("404.html", "<h1>app</h1>", "text/html", nil)
+	w = serve(s, httptest.NewRequest("GET", "/gridfs/route", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "public, max-age=3600" {
+		t.Errorf("got %d %q", w.Code, w.Header().Get("Cache-Control"))
+	}
+}
+
+func TestServeByObjectId(t *testing.T) {
+
+	conf := testConfig()
+	conf.AllowDelete = true
+	s, gfs := newTestServer(t, conf)
+	file := gfs.put("a.txt", "by object id", "text/plain", nil)
+	hex := file.id.(bson.ObjectId).Hex()
+	named := gfs.put("b.txt", "by string id", "text/plain", nil)
+	named.id = "logo"
+
+	for path, want := range map[string]string{
+		"/gridfs/" + hex + "?by=id": "by object id",
+		"/gridfs/logo?by=id":        "by string id",
+	} {
+		w := serve(s, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK || w.Body.String() != want {
+			t.Errorf("%s: got %d %q", path, w.Code, w.Body.String())
+		}
+	}
+
+	if w := serve(s, httptest.NewRequest("DELETE", "/gridfs/"+hex+"?by=id", nil)); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: got %d %s", w.Code, w.Body.String())
+	}
+	if gfs.get(file.id) != nil {
+		t.Errorf("%s is still stored", hex)
+	}
+}
+
+func TestStripAndPathPrefix(t *testing.T) {
+
+	for _, strip := range []string{"/assets", "/assets/"} {
+		conf := testConfig()
+		conf.StripPrefix = strip
+		conf.PathPrefix = "images/"
+		s, gfs := newTestServer(t, conf)
+		gfs.put("images/logo.png", "logo", "image/png", nil)
+		gfs.put("logo.png", "unprefixed", "image/png", nil)
+
+		for path, want := range map[string]int{
+			"/assets/gridfs/logo.png":  http.StatusOK,
+			"/gridfs/logo.png":         http.StatusOK,
+			"/assetsx/gridfs/logo.png": http.StatusNotFound,
+			"/assets":                  http.StatusNotFound,
+		} {
+			w := serve(s, httptest.NewRequest("GET", path, nil))
+			if w.Code != want || want == http.StatusOK && w.Body.String() != "logo" {
+				t.Errorf("%s %s: got %d %q", strip, path, w.Code, w.Body.String())
+			}
+		}
+	}
+}
+
+// file whose read reaching failAt bytes returns them together with an error
+type failingRead struct {
+	gridFile
+	read   int
+	failAt int
+}
+
+func (f *failingRead) Read(p []byte) (int, error) {
+	n, err := f.gridFile.Read(p)
+	if f.read += n; f.read >= f.failAt {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func TestStreamFileErrors(t *testing.T) {
+
+	conf := testConfig()
+	conf.ReadBufferSize = 4
+	s, gfs := newTestServer(t, conf)
+	gfs.put("a.txt", "hello world", "text/plain", nil).failAfter = 5
+	gfs.put("b.txt", "hello world", "text/plain", nil)
+
+	open := func(name string) gridFile {
+		gfsFile, err := gfs.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return gfsFile
+	}
+
+	var out bytes.Buffer
+	written, err := s.streamFile(context.Background(), &out, open("a.txt"), 11)
+	if err == nil || err == io.EOF || written != 5 || out.String() != "hello" {
+		t.Errorf("failing partway: got %d %q %v", written, out.String(), err)
+	}
+
+	out.Reset()
+	written, err = s.streamFile(context.Background(), &out, &failingRead{gridFile: open("b.txt"), failAt: 6}, 11)
+	if err == nil || written != 8 || out.String() != "hello wo" {
+		t.Errorf("failing along with data: got %d %q %v", written, out.String(), err)
+	}
+
+	out.Reset()
+	written, err = s.streamFile(context.Background(), &out, open("b.txt"), 20)
+	if err != io.ErrUnexpectedEOF || written != 11 {
+		t.Errorf("ending early: got %d %v", written, err)
+	}
+
+	out.Reset()
+	written, err = s.streamFile(context.Background(), &out, open("b.txt"), 11)
+	if err != nil || written != 11 || out.String() != "hello world" {
+		t.Errorf("whole file: got %d %q %v", written, out.String(), err)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+
+	missing := filepath.Join(t.TempDir(), "missing.json")
+	if _, err := loadConfig(missing); err == nil || err.Error() != "config file "+missing+" not found" {
+		t.Errorf("missing file: got %v", err)
+	}
+
+	for content, want := range map[string]string{
+		"{\n  \"servers\": [\"a\"],\n  \"debug\": tru\n}": "at line 3, column 15",
+		"{\n\"readbuffersize\": \"x\"}":                   "at line 2, column 21",
+	} {
+		file := configFile(t, "config.json", content)
+		_, err := loadConfig(file)
+		if err == nil || !strings.Contains(err.Error(), "invalid JSON in config file "+file) || !strings.Contains(err.Error(), want) {
+			t.Errorf("%q: got %v", content, err)
+		}
+	}
+
+	file := configFile(t, "config.yaml", "servers: [a\n")
+	if _, err := loadConfig(file); err == nil || !strings.Contains(err.Error(), "invalid YAML in config file "+file) {
+		t.Errorf("yaml: got %v", err)
+	}
+}
+
+func TestVideoRangeProbe(t *testing.T) {
+
+	s, gfs := newTestServer(t, testConfig())
+	gfs.put("movie.mp4", strings.Repeat("v", 1<<20), "video/mp4", nil)
+
+	// players probe the first bytes, then seek close to the end
+	for header, want := range map[string]string{
+		"bytes=0-1":      "bytes 0-1/1048576",
+		"bytes=1048570-": "bytes 1048570-1048575/1048576",
+	} {
+		gfs.read.Store(0)
+		r := httptest.NewRequest("GET", "/gridfs/movie.mp4", nil)
+		r.Header.Set("Range", header)
+		w := serve(s, r)
+		if w.Code != http.StatusPartialContent || w.Header().Get("Content-Range") != want || w.Header().Get("Content-Type") != "video/mp4" {
+			t.Errorf("%s: got %d %q %s", header, w.Code, w.Header().Get("Content-Range"), w.Header().Get("Content-Type"))
+		}
+		// seeking, not reading up to the range
+		if read := gfs.read.Load(); read != int64(w.Body.Len()) {
+			t.Errorf("%s: read %d bytes for %d sent", header, read, w.Body.Len())
+		}
+	}
+}
+
+func BenchmarkVideoRangeProbe(b *testing.B) {
+
+	s, gfs := newTestServer(b, testConfig())
+	gfs.put("movie.mp4", strings.Repeat("v", 64<<20), "video/mp4", nil)
+
+	// a range near the end of a large video costs as much as one at its start
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := httptest.NewRequest("GET", "/gridfs/movie.mp4", nil)
+		r.Header.Set("Range", "bytes=67108000-")
+		if w := serve(s, r); w.Code != http.StatusPartialContent {
+			b.Fatalf("got %d", w.Code)
+		}
+	}
+}
+
+func TestHandlePathBoundaries(t *testing.T) {
+
+	conf := testConfig()
+	conf.HandlePath = "/files"
+	s, gfs := newTestServer(t, conf)
+	gfs.put("a.txt", "a", "text/plain", nil)
+
+	if w := serve(s, httptest.NewRequest("GET", "/files", nil)); w.Code/100 != 3 || w.Header().Get("Location") != "/files/" {
+		t.Errorf("/files: got %d %s", w.Code, w.Header().Get("Location"))
+	}
+	if w := serve(s, httptest.NewRequest("GET", "/files/", nil)); w.Code != http.StatusBadRequest {
+		t.Errorf("/files/: got %d", w.Code)
+	}
+	if w := serve(s, httptest.NewRequest("GET", "/files/a.txt", nil)); w.Code != http.StatusOK || w.Body.String() != "a" {
+		t.Errorf("/files/a.txt: got %d %q", w.Code, w.Body.String())
+	}
+	if w := serve(s, httptest.NewRequest("GET", "/filesa.txt", nil)); w.Code != http.StatusNotFound {
+		t.Errorf("/filesa.txt: got %d", w.Code)
+	}
+
+	// the directory is served its index file
+	conf.IndexFile = "index.html"
+	s, gfs = newTestServer(t, conf)
+	gfs.put("index.html", "home", "text/html", nil)
+	if w := serve(s, httptest.NewRequest("GET", "/files/", nil)); w.Code != http.StatusOK || w.Body.String() != "home" {
+		t.Errorf("/files/ with an index file: got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestBufferedCompression(t *testing.T) {
+
+	conf := testConfig()
+	conf.Compress = true
+	conf.BufferMaxBytes = 100
+	s, gfs := newTestServer(t, conf)
+
+	// files up to the limit are compressed in memory for a Content-Length, larger ones stream
+	for size, buffered := range map[int]bool{100: true, 101: false} {
+		name := fmt.Sprintf("%d.txt", size)
+		gfs.put(name, strings.Repeat("a", size), "text/plain", nil)
+
+		r := httptest.NewRequest("GET", "/gridfs/"+name, nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := serve(s, r)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("%s: got %d %q", name, w.Code, w.Header().Get("Content-Encoding"))
+		}
+		length := w.Header().Get("Content-Length")
+		if buffered && length != strconv.Itoa(w.Body.Len()) || !buffered && length != "" {
+			t.Errorf("%s: Content-Length %q for %d bytes", name, length, w.Body.Len())
+		}
+		gz, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		content, err := io.ReadAll(gz)
+		if err != nil || string(content) != strings.Repeat("a", size) {
+			t.Errorf("%s: got %d bytes %v", name, len(content), err)
+		}
+	}
+}
+
+func TestMultipartRanges(t *testing.T) {
+
+	s, gfs := newTestServer(t, testConfig())
+	gfs.put("a.txt", "0123456789", "text/plain", nil)
+
+	r := httptest.NewRequest("GET", "/gridfs/a.txt", nil)
+	r.Header.Set("Range", "bytes=0-1, 5-6, -2")
+	w := serve(s, r)
+	mediatype, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if w.Code != http.StatusPartialContent || err != nil || mediatype != "multipart/byteranges" {
+		t.Fatalf("got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	if w.Header().Get("Content-Length") != strconv.Itoa(w.Body.Len()) {
+		t.Errorf("Content-Length %s for %d bytes", w.Header().Get("Content-Length"), w.Body.Len())
+	}
+
+	parts := multipart.NewReader(w.Body, params["boundary"])
+	for _, want := range []struct{ crange, body string }{
+		{"bytes 0-1/10", "01"},
+		{"bytes 5-6/10", "56"},
+		{"bytes 8-9/10", "89"},
+	} {
+		part, err := parts.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(part)
+		if part.Header.Get("Content-Range") != want.crange || part.Header.Get("Content-Type") != "text/plain" || string(body) != want.body {
+			t.Errorf("got %q %q %q, want %s %s", part.Header.Get("Content-Range"), part.Header.Get("Content-Type"), body, want.crange, want.body)
+		}
+	}
+	if _, err := parts.NextPart(); err != io.EOF {
+		t.Errorf("after the last part: %v", err)
+	}
+}
+
+func TestMultipleRangesOfEncodedFile(t *testing.T) {
+
+	s, gfs := newTestServer(t, testConfig())
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte("body { color: red }"))
+	gz.Close()
+	gfs.put("style.css", gzipped.String(), "text/css", bson.M{"gzip": true})
+
+	// the parts would be labeled gzip as one body, so the whole file is sent
+	r := httptest.NewRequest("GET", "/gridfs/style.css", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	r.Header.Set("Range", "bytes=0-1, 4-5")
+	w := serve(s, r)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Content-Type") != "text/css" || w.Body.String() != gzipped.String() {
+		t.Errorf("got %d %q %s with %d bytes", w.Code, w.Header().Get("Content-Encoding"), w.Header().Get("Content-Type"), w.Body.Len())
+	}
+}
+
+func TestH2C(t *testing.T) {
+
+	conf := testConfig()
+	conf.H2C = true
+	s, gfs := newTestServer(t, conf)
+	gfs.put("a.txt", "a", "text/plain", nil)
+	gfs.put("b.txt", "b", "text/plain", nil)
+
+	var connections atomic.Int32
+	ts := httptest.NewUnstartedServer(s.routes())
+	ts.Config.Protocols = serverProtocols(conf)
+	ts.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	transport := &http.Transport{Protocols: new(http.Protocols)}
+	transport.Protocols.SetUnencryptedHTTP2(true)
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport}
+	for _, name := range []string{"a.txt", "b.txt", "a.txt"} {
+		res, err := client.Get(ts.URL + "/gridfs/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.ProtoMajor != 2 || string(body) != name[:1] {
+			t.Errorf("%s: got %s %q", name, res.Proto, body)
+		}
+	}
+	if connections.Load() != 1 {
+		t.Errorf("used %d connections", connections.Load())
+	}
+}
+
+func TestPathPatterns(t *testing.T) {
+
+	conf := testConfig()
+	conf.PathPatterns = []string{"/img/{path...}", "GET /img/thumbs/{path}"}
+	s, gfs := newTestServer(t, conf)
+	gfs.put("a.png", "thumb", "image/png", nil)
+	gfs.put("thumbs/a.png", "nested", "image/png", nil)
+	gfs.put("thumbs/deep/a.png", "deep", "image/png", nil)
+
+	// the most specific pattern names the file
+	for path, want := range map[string]string{
+		"/img/thumbs/a.png":      "thumb",
+		"/img/thumbs/deep/a.png": "deep",
+		"/gridfs/thumbs/a.png":   "nested",
+	} {
+		w := serve(s, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusOK || w.Body.String() != want {
+			t.Errorf("%s: got %d %q, want %q", path, w.Code, w.Body.String(), want)
+		}
+	}
+
+	// the GET pattern leaves other methods to the wider one
+	conf.AllowDelete = true
+	s, gfs = newTestServer(t, conf)
+	gfs.put("thumbs/a.png", "nested", "image/png", nil)
+	if w := serve(s, httptest.NewRequest("DELETE", "/img/thumbs/a.png", nil)); w.Code != http.StatusNoContent || len(gfs.names()) != 0 {
+		t.Errorf("DELETE: got %d, left %v", w.Code, gfs.names())
+	}
+}
+
+func TestDownloadQuery(t *testing.T) {
+
+	conf := testConfig()
+	conf.Disposition = "inline"
+	s, gfs := newTestServer(t, conf)
+	gfs.put("photos/отпуск.jpg", "jpeg", "image/jpeg", nil)
+
+	for query, want := range map[string]string{
+		"":                                   "inline",
+		"?download=1":                        "attachment",
+		"?download=0":                        "inline",
+		"?download=1&disposition=inline":     "inline",
+		"?disposition=attachment&download=0": "attachment",
+	} {
+		w := serve(s, httptest.NewRequest("GET", "/gridfs/photos/%D0%BE%D1%82%D0%BF%D1%83%D1%81%D0%BA.jpg"+query, nil))
+		if got := w.Header().Get("Content-Disposition"); got != contentDisposition(want, "отпуск.jpg") {
+			t.Errorf("%q: got %q", query, got)
+		}
+	}
+	if w := serve(s, httptest.NewRequest("GET", "/gridfs/photos/%D0%BE%D1%82%D0%BF%D1%83%D1%81%D0%BA.jpg?disposition=none", nil)); w.Header().Get("Content-Disposition") != "" {
+		t.Errorf("none: got %q", w.Header().Get("Content-Disposition"))
+	}
+}
+
+func TestEmptyFile(t *testing.T) {
+
+	conf := testConfig()
+	conf.Compress = true
+	conf.VerifyMD5 = true
+	s, gfs := newTestServer(t, conf)
+	gfs.put("empty", "", "", nil)
+	gfs.put("empty.txt", "", "text/plain", bson.M{"gzip": true})
+
+	for _, name := range []string{"empty", "empty.txt"} {
+		r := httptest.NewRequest("GET", "/gridfs/"+name, nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := serve(s, r)
+		if w.Code != http.StatusOK || w.Body.Len() != 0 || w.Header().Get("Content-Length") != "0" || w.Header().Get("Content-Encoding") != "" {
+			t.Errorf("%s: got %d %q length %q encoding %q", name, w.Code, w.Body.String(), w.Header().Get("Content-Length"), w.Header().Get("Content-Encoding"))
+		}
+	}
+
+	// nothing sniffed, DetectContentType would call it text
+	w := serve(s, httptest.NewRequest("GET", "/gridfs/empty", nil))
+	if w.Header().Get("Content-Type") != "application/octet-stream" {
+		t.Errorf("got %s", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestCollectionSuffix(t *testing.T) {
+
+	for _, collection := range []string{"fs.files", "fs.chunks"} {
+		conf := testConfig()
+		conf.GridFSCollection = collection
+		stores := newMemStores()
+		s, err := newServer(conf, stores, log.New(io.Discard, "", 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stores.bucket("test", "fs").put("a.txt", "hello", "text/plain", nil)
+
+		// mgo adds the suffix itself, so the bucket is fs
+		if w := serve(s, httptest.NewRequest("GET", "/gridfs/a.txt", nil)); w.Code != http.StatusOK || w.Body.String() != "hello" {
+			t.Errorf("%s: got %d %q", collection, w.Code, w.Body.String())
+		}
+	}
+}
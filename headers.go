@@ -0,0 +1,13 @@
+package main
+
+import (
+	"net/http"
+)
+
+// add the configured extra headers to a response
+// they are set first, so headers set by the handler itself take precedence
+func setExtraHeaders(w http.ResponseWriter, conf config) {
+	for name, value := range conf.ExtraHeaders {
+		w.Header().Set(name, value)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// handle health checks by pinging mongodb
+// in maintenance mode the process is healthy without mongodb
+func (s *server) healthHandler(w http.ResponseWriter, r *http.Request) {
+
+	if s.conf().Maintenance {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, "maintenance")
+		return
+	}
+
+	// every connection the mounts read from must be up
+	err := s.Stores.ping()
+	if err != nil {
+		s.logError(w, err)
+		s.writeError(w, r, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, "ok")
+}
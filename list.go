@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"labix.org/v2/mgo/bson"
+)
+
+// default and maximum number of files per listing
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// fields listings can be sorted by, by sort parameter
+var listSorts = map[string]string{
+	"filename":   "filename",
+	"uploadDate": "uploadDate",
+	"size":       "length",
+}
+
+// file returned by the list handler
+type listEntry struct {
+	Filename    string    `json:"filename" bson:"filename"`
+	Length      int64     `json:"length" bson:"length"`
+	UploadDate  time.Time `json:"uploadDate" bson:"uploadDate"`
+	ContentType string    `json:"contentType,omitempty" bson:"contentType,omitempty"`
+}
+
+// handle requests listing the files of the mount whose name starts with a prefix
+// supports the limit and skip query parameters for pagination
+// and sort (filename, uploadDate or size) with order (asc or desc)
+func (m *mount) listHandler(w http.ResponseWriter, r *http.Request) {
+
+	conf := m.srv.conf()
+
+	if !m.srv.checkRateLimit(w, r) {
+		return
+	}
+	if !m.srv.checkMaintenance(w, r, conf) {
+		return
+	}
+	if handleCORS(w, r, conf) {
+		return
+	}
+	if !m.srv.checkAuth(w, r, conf) {
+		return
+	}
+
+	m, err := m.withDatabase(r)
+	if err != nil {
+		m.srv.writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// cut listpath from URL path
+	// remainder will be the filename prefix
+	prefix := strings.TrimPrefix(r.URL.Path, m.ListPath)
+	prefix, err = cleanPath(prefix)
+	if err != nil {
+		m.srv.writeError(w, r, "invalid path", http.StatusBadRequest)
+		return
+	}
+
+	limit, err := queryInt(r, "limit", defaultListLimit)
+	if err != nil || limit < 1 || limit > maxListLimit {
+		m.srv.writeError(w, r, "invalid limit", http.StatusBadRequest)
+		return
+	}
+	skip, err := queryInt(r, "skip", 0)
+	if err != nil || skip < 0 {
+		m.srv.writeError(w, r, "invalid skip", http.StatusBadRequest)
+		return
+	}
+
+	// only allowed fields, arbitrary sorts could bypass indexes
+	sort := "filename"
+	if value := r.URL.Query().Get("sort"); value != "" {
+		var ok bool
+		if sort, ok = listSorts[value]; !ok {
+			m.srv.writeError(w, r, "invalid sort", http.StatusBadRequest)
+			return
+		}
+	}
+	switch r.URL.Query().Get("order") {
+	case "", "asc":
+	case "desc":
+		sort = "-" + sort
+	default:
+		m.srv.writeError(w, r, "invalid order", http.StatusBadRequest)
+		return
+	}
+
+	// listings show filenames as requested, without the path prefix
+	prefix = m.PathPrefix + prefix
+	query := bson.M{}
+	if prefix != "" {
+		query["filename"] = bson.RegEx{Pattern: "^" + regexp.QuoteMeta(prefix)}
+	}
+
+	entries := []listEntry{}
+	err = m.GFS.Find(query).Sort(sort).Skip(skip).Limit(limit).All(&entries)
+	if err != nil {
+		m.srv.mongoError()
+		m.srv.logError(w, err)
+		m.srv.writeError(w, r, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	for i := range entries {
+		entries[i].Filename = strings.TrimPrefix(entries[i].Filename, m.PathPrefix)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(entries)
+	if err != nil {
+		m.srv.logError(w, err)
+	}
+}
+
+// integer query parameter with a default for missing ones
+func queryInt(r *http.Request, name string, def int) (int, error) {
+
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+
+	return strconv.Atoi(value)
+}
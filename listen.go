@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strings"
+)
+
+// open a listener for a tcp address or a unix socket path prefixed with "unix:"
+// stale sockets left behind by an earlier run are removed first
+func listen(address string) (net.Listener, error) {
+
+	path := strings.TrimPrefix(address, "unix:")
+	if path == address {
+		return net.Listen("tcp", address)
+	}
+
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+
+	return net.Listen("unix", path)
+}
+
+// addresses served by the main listeners, listeners replaces listen
+func listenAddresses(conf config) []string {
+
+	if len(conf.Listeners) > 0 {
+		return conf.Listeners
+	}
+
+	return []string{conf.Listen}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// verbosity of the log, errors are always logged
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevels = map[string]logLevel{
+	"debug": levelDebug,
+	"info":  levelInfo,
+	"warn":  levelWarn,
+	"error": levelError,
+}
+
+// configured log level
+// without a loglevel the debug flag picks debug or info
+func (conf config) logLevel() logLevel {
+
+	if level, ok := logLevels[strings.ToLower(conf.LogLevel)]; ok {
+		return level
+	}
+	if conf.Debug {
+		return levelDebug
+	}
+
+	return levelInfo
+}
+
+// log at the given level if the configured one lets it through
+func (s *server) logAt(level logLevel, v ...interface{}) {
+	if level >= s.conf().logLevel() {
+		s.Logger.Output(3, fmt.Sprintln(v...))
+	}
+}
+
+func (s *server) debug(v ...interface{}) { s.logAt(levelDebug, v...) }
+func (s *server) info(v ...interface{})  { s.logAt(levelInfo, v...) }
+func (s *server) warn(v ...interface{})  { s.logAt(levelWarn, v...) }
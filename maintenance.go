@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// answer requests with 503 while in maintenance mode
+// returns false when the request must not be served
+func (s *server) checkMaintenance(w http.ResponseWriter, r *http.Request, conf config) bool {
+
+	if !conf.Maintenance {
+		return true
+	}
+
+	retryAfter := conf.MaintenanceRetry
+	if retryAfter <= 0 {
+		retryAfter = 60
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	s.writeError(w, r, "down for maintenance", http.StatusServiceUnavailable)
+
+	return false
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"labix.org/v2/mgo"
+)
+
+// file information returned by the meta handler
+type fileMeta struct {
+	Id          interface{} `json:"_id"`
+	Filename    string      `json:"filename"`
+	Length      int64       `json:"length"`
+	ContentType string      `json:"contentType"`
+	UploadDate  time.Time   `json:"uploadDate"`
+	MD5         string      `json:"md5"`
+	Verified    *bool       `json:"verified,omitempty"`
+	// whether the mount may serve the file
+	allowed bool
+}
+
+// handle requests for file information of the mount without the file content
+func (m *mount) metaHandler(w http.ResponseWriter, r *http.Request) {
+
+	conf := m.srv.conf()
+
+	if !m.srv.checkRateLimit(w, r) {
+		return
+	}
+	if !m.srv.checkMaintenance(w, r, conf) {
+		return
+	}
+	if handleCORS(w, r, conf) {
+		return
+	}
+	if !m.srv.checkAuth(w, r, conf) {
+		return
+	}
+
+	m, err := m.withDatabase(r)
+	if err != nil {
+		m.srv.writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m, err = m.withField(r)
+	if err != nil {
+		m.srv.writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// cut metapath from URL path
+	// remainder will be the filename to look up in GridFS
+	path := strings.TrimPrefix(r.URL.Path, m.MetaPath)
+	path, err = cleanPath(path)
+	if err != nil {
+		m.srv.writeError(w, r, "invalid path", http.StatusBadRequest)
+		return
+	}
+	if path == "" {
+		m.srv.writeError(w, r, "no file name given", http.StatusBadRequest)
+		return
+	}
+
+	m.srv.debug(path)
+
+	version, err := queryInt(r, "version", -1)
+	if err != nil {
+		m.srv.writeError(w, r, "invalid version", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := m.srv.mongoContext(r)
+	defer cancel()
+
+	// concurrent requests for the same file information share one lookup
+	verify := r.URL.Query().Get("verify") == "1"
+	key := fmt.Sprintf("meta\x00%s\x00%s\x00%s\x00%s\x00%d\x00%t", m.Database, m.GridFSCollection, m.Field, m.filename(path), version, verify)
+	shared, err := m.srv.coalesce(ctx, key, func() (interface{}, error) {
+		return m.lookupMeta(ctx, path, version, verify)
+	})
+	if err == mgo.ErrNotFound {
+		m.srv.writeError(w, r, "file not found", http.StatusNotFound)
+		return
+	} else if err != nil && r.Context().Err() == context.Canceled {
+		m.srv.logCanceled(w, r)
+		return
+	} else if err == context.DeadlineExceeded {
+		m.srv.logError(w, fmt.Errorf("lookup of %s timed out", path))
+		m.srv.writeError(w, r, "gateway timeout", http.StatusGatewayTimeout)
+		return
+	} else if err != nil {
+		m.srv.logError(w, err)
+		m.srv.writeError(w, r, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// the allow-list hides files like it does for downloads
+	meta := shared.(fileMeta)
+	if !meta.allowed {
+		m.srv.writeError(w, r, "file type not allowed", http.StatusForbidden)
+		return
+	}
+	if meta.Verified != nil && !*meta.Verified {
+		m.srv.logError(w, fmt.Errorf("CORRUPTION: md5 of %s (%v) doesn't match the stored %s", meta.Filename, meta.Id, meta.MD5))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(meta)
+	if err != nil {
+		m.srv.logError(w, err)
+	}
+}
+
+// look up the information of a file, with verify checking its content against the stored md5
+func (m *mount) lookupMeta(ctx context.Context, path string, version int, verify bool) (meta fileMeta, err error) {
+
+	gfsFile, err := m.srv.getFile(ctx, m.GFS, m.filename(path), m.Field, version)
+	if err != nil {
+		return
+	}
+	defer gfsFile.Close()
+
+	meta = fileMeta{
+		Id:          gfsFile.Id(),
+		Filename:    gfsFile.Name(),
+		Length:      gfsFile.Size(),
+		ContentType: gfsFile.ContentType(),
+		UploadDate:  gfsFile.UploadDate(),
+		MD5:         gfsFile.MD5(),
+	}
+
+	// types are only sniffed for mounts restricted to some
+	ctype := gfsFile.ContentType()
+	if len(m.AllowedTypes) > 0 {
+		encoding := ""
+		if gfsFile.Size() > 0 {
+			encoding = storedEncoding(gfsFile)
+		}
+		ctype, err = m.storedType(gfsFile, encoding)
+		if err != nil {
+			return
+		}
+	}
+	meta.allowed = m.allowed(meta.Filename, ctype)
+	if !meta.allowed {
+		return
+	}
+
+	// ?verify=1 reads the whole file to check it against the stored md5
+	if verify && meta.MD5 != "" {
+		var verified bool
+		verified, err = m.srv.verifyFile(ctx, gfsFile)
+		if err != nil {
+			return
+		}
+		meta.Verified = &verified
+	}
+
+	return
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// upper bounds of the request duration histogram in seconds
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// request and mongodb metrics in prometheus format
+// all methods are no-ops on a nil *metrics, which is the case when disabled
+type metrics struct {
+	lock           sync.Mutex
+	requests       map[int]uint64 // by status
+	durationCounts []uint64       // by bucket, not cumulative
+	durationSum    float64
+	durationCount  uint64
+	bytesServed    uint64
+	mongoErrors    uint64
+	inFlight       int64 // file downloads
+	coalescedReads uint64
+	typeHits       uint64
+	typeMisses     uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:       make(map[int]uint64),
+		durationCounts: make([]uint64, len(durationBuckets)),
+	}
+}
+
+// record a finished request
+func (m *metrics) observeRequest(status int, bytes int64, duration time.Duration) {
+
+	if m == nil {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.requests[status]++
+	m.bytesServed += uint64(bytes)
+
+	seconds := duration.Seconds()
+	m.durationSum += seconds
+	m.durationCount++
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			m.durationCounts[i]++
+			break
+		}
+	}
+}
+
+// record a failed mongodb operation
+func (m *metrics) mongoError() {
+
+	if m == nil {
+		return
+	}
+
+	m.lock.Lock()
+	m.mongoErrors++
+	m.lock.Unlock()
+}
+
+// record a request sharing the mongodb read of an identical one
+func (m *metrics) coalesced() {
+
+	if m == nil {
+		return
+	}
+
+	m.lock.Lock()
+	m.coalescedReads++
+	m.lock.Unlock()
+}
+
+// record a content type found in the type cache
+func (m *metrics) typeCacheHit() {
+
+	if m == nil {
+		return
+	}
+
+	m.lock.Lock()
+	m.typeHits++
+	m.lock.Unlock()
+}
+
+// record a content type sniffed for lack of a cached one
+func (m *metrics) typeCacheMiss() {
+
+	if m == nil {
+		return
+	}
+
+	m.lock.Lock()
+	m.typeMisses++
+	m.lock.Unlock()
+}
+
+// record a started file download
+func (m *metrics) downloadStarted() {
+
+	if m == nil {
+		return
+	}
+
+	m.lock.Lock()
+	m.inFlight++
+	m.lock.Unlock()
+}
+
+// record a finished file download
+func (m *metrics) downloadFinished() {
+
+	if m == nil {
+		return
+	}
+
+	m.lock.Lock()
+	m.inFlight--
+	m.lock.Unlock()
+}
+
+// handle metrics scrapes in the prometheus text format
+func (m *metrics) handler(w http.ResponseWriter, r *http.Request) {
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP gogridfs_requests_total Number of HTTP requests by status code.")
+	fmt.Fprintln(w, "# TYPE gogridfs_requests_total counter")
+	statuses := make([]int, 0, len(m.requests))
+	for status := range m.requests {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	for _, status := range statuses {
+		fmt.Fprintf(w, "gogridfs_requests_total{status=\"%d\"} %d\n", status, m.requests[status])
+	}
+
+	fmt.Fprintln(w, "# HELP gogridfs_request_duration_seconds Duration of HTTP requests.")
+	fmt.Fprintln(w, "# TYPE gogridfs_request_duration_seconds histogram")
+	var cumulative uint64
+	for i, bound := range durationBuckets {
+		cumulative += m.durationCounts[i]
+		fmt.Fprintf(w, "gogridfs_request_duration_seconds_bucket{le=\"%g\"} %d\n", bound, cumulative)
+	}
+	fmt.Fprintf(w, "gogridfs_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.durationCount)
+	fmt.Fprintf(w, "gogridfs_request_duration_seconds_sum %g\n", m.durationSum)
+	fmt.Fprintf(w, "gogridfs_request_duration_seconds_count %d\n", m.durationCount)
+
+	fmt.Fprintln(w, "# HELP gogridfs_bytes_served_total Number of response body bytes sent.")
+	fmt.Fprintln(w, "# TYPE gogridfs_bytes_served_total counter")
+	fmt.Fprintf(w, "gogridfs_bytes_served_total %d\n", m.bytesServed)
+
+	fmt.Fprintln(w, "# HELP gogridfs_mongo_errors_total Number of failed mongodb operations.")
+	fmt.Fprintln(w, "# TYPE gogridfs_mongo_errors_total counter")
+	fmt.Fprintf(w, "gogridfs_mongo_errors_total %d\n", m.mongoErrors)
+
+	fmt.Fprintln(w, "# HELP gogridfs_downloads_in_flight Number of file downloads in progress.")
+	fmt.Fprintln(w, "# TYPE gogridfs_downloads_in_flight gauge")
+	fmt.Fprintf(w, "gogridfs_downloads_in_flight %d\n", m.inFlight)
+
+	fmt.Fprintln(w, "# HELP gogridfs_coalesced_reads_total Number of requests sharing the mongodb read of a concurrent identical one.")
+	fmt.Fprintln(w, "# TYPE gogridfs_coalesced_reads_total counter")
+	fmt.Fprintf(w, "gogridfs_coalesced_reads_total %d\n", m.coalescedReads)
+
+	fmt.Fprintln(w, "# HELP gogridfs_type_cache_lookups_total Number of sniffed content types looked up in the type cache by result.")
+	fmt.Fprintln(w, "# TYPE gogridfs_type_cache_lookups_total counter")
+	fmt.Fprintf(w, "gogridfs_type_cache_lookups_total{result=\"hit\"} %d\n", m.typeHits)
+	fmt.Fprintf(w, "gogridfs_type_cache_lookups_total{result=\"miss\"} %d\n", m.typeMisses)
+}
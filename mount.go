@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var errDatabaseNotAllowed = errors.New("database not allowed")
+
+// error for by query parameters naming no known field
+var errFieldNotAllowed = errors.New(`by must be "id" or "filename"`)
+
+// lookup fields selectable with the by query parameter
+var lookupFields = map[string]string{
+	"id":       "_id",
+	"_id":      "_id",
+	"filename": "filename",
+}
+
+// config of a gridfs collection served below its own path prefix
+// empty fields fall back to the top level config
+type mountConfig struct {
+	HandlePath        string   `json:"handlepath" yaml:"handlepath"`
+	MetaPath          string   `json:"metapath" yaml:"metapath"`
+	ListPath          string   `json:"listpath" yaml:"listpath"`
+	ZipPath           string   `json:"zippath" yaml:"zippath"`           // zip archives of several files, disabled if empty
+	PathPatterns      []string `json:"pathpatterns" yaml:"pathpatterns"` // further routes like "/img/{path...}" serving files named by path
+	Database          string   `json:"database" yaml:"database"`
+	Servers           []string `json:"servers" yaml:"servers"`   // own mongodb servers, the top level connection if empty
+	MongoURI          string   `json:"mongouri" yaml:"mongouri"` // own connection string replacing servers
+	Mode              string   `json:"mode" yaml:"mode"`         // own mgo mode: strong, monotonic or eventual
+	GridFSCollection  string   `json:"gridfscollection" yaml:"gridfscollection"`
+	Field             string   `json:"field" yaml:"field"`                         // _id, filename, metadata.<key>
+	PathPrefix        string   `json:"pathprefix" yaml:"pathprefix"`               // prepended to requested filenames
+	AllowedExtensions []string `json:"allowedextensions" yaml:"allowedextensions"` // like ".png", empty allows all
+	AllowedTypes      []string `json:"allowedtypes" yaml:"allowedtypes"`           // like "image/*", empty allows all
+}
+
+// a gridfs collection served below a path prefix
+type mount struct {
+	mountConfig
+	GFS      gridStore
+	WriteGFS gridStore // uploads and deletes
+	srv      *server
+}
+
+// mounts to serve
+// without configured mounts the top level fields make up a single one
+func mountConfigs(conf config) (mounts []mountConfig) {
+
+	if len(conf.Mounts) == 0 {
+		return []mountConfig{{
+			HandlePath:        withSlash(conf.HandlePath),
+			MetaPath:          withSlash(conf.MetaPath),
+			ListPath:          withSlash(conf.ListPath),
+			ZipPath:           conf.ZipPath,
+			PathPatterns:      conf.PathPatterns,
+			Database:          conf.Database,
+			GridFSCollection:  conf.GridFSCollection,
+			Field:             conf.Field,
+			PathPrefix:        conf.PathPrefix,
+			AllowedExtensions: conf.AllowedExtensions,
+			AllowedTypes:      conf.AllowedTypes,
+		}}
+	}
+
+	for _, m := range conf.Mounts {
+		m.HandlePath = withSlash(m.HandlePath)
+		m.MetaPath = withSlash(m.MetaPath)
+		m.ListPath = withSlash(m.ListPath)
+		if m.Database == "" {
+			m.Database = conf.Database
+		}
+		if m.GridFSCollection == "" {
+			m.GridFSCollection = conf.GridFSCollection
+		}
+		if m.Field == "" {
+			m.Field = conf.Field
+		}
+		if m.PathPrefix == "" {
+			m.PathPrefix = conf.PathPrefix
+		}
+		if m.AllowedExtensions == nil {
+			m.AllowedExtensions = conf.AllowedExtensions
+		}
+		if m.AllowedTypes == nil {
+			m.AllowedTypes = conf.AllowedTypes
+		}
+		mounts = append(mounts, m)
+	}
+
+	return
+}
+
+// gridfs prefix of a collection name
+// mgo adds .files and .chunks itself, names configured with either are trimmed
+func bucketName(collection string) (prefix string, trimmed bool) {
+
+	for _, suffix := range []string{".files", ".chunks"} {
+		if strings.HasSuffix(collection, suffix) {
+			return strings.TrimSuffix(collection, suffix), true
+		}
+	}
+
+	return collection, false
+}
+
+// paths of a mount end in a slash so they match everything below them,
+// requests without the slash are redirected to it
+func withSlash(path string) string {
+
+	if path == "" || strings.HasSuffix(path, "/") {
+		return path
+	}
+
+	return path + "/"
+}
+
+// file name of a request, the path wildcard of a matched path pattern
+// or the remainder after the handlepath
+func (m *mount) requestPath(r *http.Request) string {
+
+	if strings.Contains(r.Pattern, "{path}") || strings.Contains(r.Pattern, "{path...}") {
+		return r.PathValue("path")
+	}
+
+	return strings.TrimPrefix(r.URL.Path, m.HandlePath)
+}
+
+// gridfs filename of a requested path
+// the path prefix only applies to lookups by filename
+func (m *mount) filename(path string) string {
+
+	if m.Field == "_id" || isMetaField(m.Field) {
+		return path
+	}
+
+	return m.PathPrefix + path
+}
+
+// mount looking files up by the field of the by query parameter
+// without one, or with an invalid one, the mount stays as is
+func (m *mount) withField(r *http.Request) (*mount, error) {
+
+	by := r.URL.Query().Get("by")
+	if by == "" {
+		return m, nil
+	}
+
+	field, ok := lookupFields[by]
+	if !ok {
+		return m, errFieldNotAllowed
+	}
+	if field == m.Field {
+		return m, nil
+	}
+
+	requested := *m
+	requested.Field = field
+
+	return &requested, nil
+}
+
+// mount for the database requested with the X-Database header
+// the database must be on the allow-list, without a header, or with one not allowed, the mount stays as is
+func (m *mount) withDatabase(r *http.Request) (*mount, error) {
+
+	name := r.Header.Get("X-Database")
+	if name == "" || name == m.Database {
+		return m, nil
+	}
+
+	for _, allowed := range m.srv.conf().AllowedDatabases {
+		if name == allowed {
+			requested := &mount{mountConfig: m.mountConfig, srv: m.srv}
+			requested.Database = name
+			var err error
+			requested.GFS, requested.WriteGFS, err = m.srv.Stores.open(requested.mountConfig)
+			if err != nil {
+				return m, err
+			}
+			return requested, nil
+		}
+	}
+
+	return m, errDatabaseNotAllowed
+}
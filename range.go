@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// most ranges answered as multipart/byteranges, more get the whole file
+const maxRanges = 16
+
+// a single byte range of a file
+type byteRange struct {
+	Start  int64
+	Length int64
+}
+
+var (
+	errRangeInvalid        = errors.New("invalid range")
+	errRangeNotSatisfiable = errors.New("range not satisfiable")
+)
+
+// value for the Content-Range header
+func (br byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", br.Start, br.Start+br.Length-1, size)
+}
+
+// parse a Range header like "bytes=0-499", "bytes=500-" or "bytes=-500"
+// against a file of the given size
+// invalid headers are to be ignored, unsatisfiable ones answered with 416
+func parseRange(header string, size int64) (ranges []byteRange, err error) {
+
+	if !strings.HasPrefix(header, "bytes=") {
+		err = errRangeInvalid
+		return
+	}
+
+	for _, spec := range strings.Split(header[len("bytes="):], ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		dash := strings.Index(spec, "-")
+		if dash < 0 {
+			err = errRangeInvalid
+			return
+		}
+		first, last := strings.TrimSpace(spec[:dash]), strings.TrimSpace(spec[dash+1:])
+
+		var br byteRange
+		if first == "" {
+			// suffix range: the last n bytes
+			n, perr := strconv.ParseInt(last, 10, 64)
+			if perr != nil || n < 0 {
+				err = errRangeInvalid
+				return
+			}
+			if n == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			br = byteRange{Start: size - n, Length: n}
+		} else {
+			start, perr := strconv.ParseInt(first, 10, 64)
+			if perr != nil || start < 0 {
+				err = errRangeInvalid
+				return
+			}
+			end := size - 1
+			if last != "" {
+				end, perr = strconv.ParseInt(last, 10, 64)
+				if perr != nil || end < start {
+					err = errRangeInvalid
+					return
+				}
+				if end >= size {
+					end = size - 1
+				}
+			}
+			if start >= size {
+				continue
+			}
+			br = byteRange{Start: start, Length: end - start + 1}
+		}
+
+		ranges = append(ranges, br)
+	}
+
+	// every range was out of bounds
+	if len(ranges) == 0 {
+		err = errRangeNotSatisfiable
+	}
+
+	return
+}
+
+// headers of a part of a multipart/byteranges response
+func (br byteRange) partHeader(ctype string, size int64) textproto.MIMEHeader {
+	return textproto.MIMEHeader{
+		"Content-Type":  {ctype},
+		"Content-Range": {br.contentRange(size)},
+	}
+}
+
+// writer counting the bytes written to it
+type countingWriter struct {
+	n int64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	cw.n += int64(len(b))
+	return len(b), nil
+}
+
+// exact length of the multipart/byteranges body for the ranges
+// computed by writing the part headers only
+func multipartLength(ranges []byteRange, ctype string, size int64, boundary string) int64 {
+
+	var cw countingWriter
+	mw := multipart.NewWriter(&cw)
+	mw.SetBoundary(boundary)
+	for _, br := range ranges {
+		mw.CreatePart(br.partHeader(ctype, size))
+		cw.n += br.Length
+	}
+	mw.Close()
+
+	return cw.n
+}
+
+// stream the ranges of a gridfile as multipart/byteranges parts
+// one after another, the gridfile can only be read from one position at a time
+func (s *server) streamRanges(ctx context.Context, w io.Writer, gfsFile gridFile, ranges []byteRange, ctype string, boundary string) (err error) {
+
+	size := gfsFile.Size()
+	mw := multipart.NewWriter(w)
+	if err = mw.SetBoundary(boundary); err != nil {
+		return
+	}
+
+	for _, br := range ranges {
+		part, err := mw.CreatePart(br.partHeader(ctype, size))
+		if err != nil {
+			return err
+		}
+		if _, err = gfsFile.Seek(br.Start, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err = s.streamFile(ctx, part, gfsFile, br.Length); err != nil {
+			return err
+		}
+	}
+
+	return mw.Close()
+}
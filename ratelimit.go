@@ -0,0 +1,104 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// token bucket of a single client
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// per client token bucket rate limiter
+type rateLimiter struct {
+	lock    sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*bucket
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+
+	if burst < 1 {
+		burst = int(math.Ceil(rate))
+	}
+
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// take a token for the key
+// if there is none left, retryAfter tells when the next one is available
+func (l *rateLimiter) allow(key string) (ok bool, retryAfter time.Duration) {
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	b, found := l.buckets[key]
+	if !found {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	// refill since the last request
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	retryAfter = time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+
+	return false, retryAfter
+}
+
+// drop the buckets of clients idle long enough to be full again
+func (l *rateLimiter) cleanup() {
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if time.Since(b.last) > full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// periodically clean up idle clients
+func (l *rateLimiter) cleanupLoop(interval time.Duration) {
+	for range time.Tick(interval) {
+		l.cleanup()
+	}
+}
+
+// check the rate limit of the requesting client if configured
+// answers with 429 and returns false when the limit is exceeded
+func (s *server) checkRateLimit(w http.ResponseWriter, r *http.Request) bool {
+
+	if s.Limiter == nil {
+		return true
+	}
+
+	ok, retryAfter := s.Limiter.allow(s.clientIP(r))
+	if ok {
+		return true
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	s.writeError(w, r, "too many requests", http.StatusTooManyRequests)
+
+	return false
+}
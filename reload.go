@@ -0,0 +1,98 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+)
+
+// config fields that can be changed on SIGHUP
+// everything else requires a restart
+var reloadableFields = map[string]bool{
+	"Debug":            true,
+	"Logfile":          true,
+	"LogExcludePaths":  true,
+	"LogLevel":         true,
+	"LogSampleRate":    true,
+	"Maintenance":      true,
+	"MaintenanceRetry": true,
+	"Mode":             true,
+	"ReadPreference":   true,
+}
+
+// snapshot of the current config, safe for concurrent use
+func (s *server) conf() config {
+	s.confLock.RLock()
+	defer s.confLock.RUnlock()
+	return s.Conf
+}
+
+// replace the current config
+func (s *server) setConf(conf config) {
+	s.confLock.Lock()
+	s.Conf = conf
+	s.confLock.Unlock()
+}
+
+// reload the config file on SIGHUP
+func (s *server) reloadOnSignal(file string) {
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		s.info("received SIGHUP, reloading", file)
+		err := s.reloadConfig(file)
+		if err != nil {
+			s.Logger.Println("reload:", err)
+		}
+	}
+}
+
+// apply the reloadable fields of the config file
+// changes to other fields are logged and ignored
+func (s *server) reloadConfig(file string) (err error) {
+
+	newConf, err := loadConfig(file)
+	if err != nil {
+		return
+	}
+
+	conf := s.conf()
+	oldValue := reflect.ValueOf(&conf).Elem()
+	newValue := reflect.ValueOf(newConf)
+	for i := 0; i < oldValue.NumField(); i++ {
+		name := oldValue.Type().Field(i).Name
+		if reflect.DeepEqual(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			continue
+		}
+		if !reloadableFields[name] {
+			s.warn("reload:", name, "can't be changed without a restart, ignoring")
+			continue
+		}
+		oldValue.Field(i).Set(newValue.Field(i))
+		s.info("reload:", name, "changed")
+	}
+
+	// switch the log writer before anything else gets logged to the old one
+	if conf.Logfile != s.conf().Logfile {
+		writer, logfile, err := openLog(conf.Logfile)
+		if err != nil {
+			return err
+		}
+		s.Logger.SetOutput(writer)
+		if s.logfile != nil {
+			s.logfile.Close()
+		}
+		s.logfile = logfile
+	}
+
+	if conf.Mode != s.conf().Mode || conf.ReadPreference != s.conf().ReadPreference {
+		s.Stores.setMode(sessionMode(s.Logger, conf))
+	}
+
+	s.setConf(conf)
+
+	return
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// largest width or height resized images may have
+const maxResizeDimension = 4096
+
+// most pixels an image may have to be resized, checked before it is decoded
+const maxResizePixels = 50 * 1000 * 1000
+
+// requested size of a resized image, 0 for an unrestricted dimension
+func resizeParams(r *http.Request) (width int, height int, ok bool, err error) {
+
+	query := r.URL.Query()
+	if query.Get("w") == "" && query.Get("h") == "" {
+		return
+	}
+
+	width, err = queryInt(r, "w", 0)
+	if err != nil || width < 0 || width > maxResizeDimension {
+		return 0, 0, false, fmt.Errorf("invalid width")
+	}
+	height, err = queryInt(r, "h", 0)
+	if err != nil || height < 0 || height > maxResizeDimension {
+		return 0, 0, false, fmt.Errorf("invalid height")
+	}
+
+	ok = width > 0 || height > 0
+
+	return
+}
+
+// image types with a registered decoder
+// others like svg are served as they are
+var resizableTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+// serve an image of the mount resized to fit the w and h query parameters
+// resized images are stored in a bucket of their own for later requests
+// returns false if the file isn't an image that can be decoded, which is then served as is
+func (m *mount) resizeHandler(w http.ResponseWriter, r *http.Request, gfsFile gridFile, ctype string, width int, height int) bool {
+
+	mediatype, _, _ := strings.Cut(ctype, ";")
+	if !resizableTypes[strings.ToLower(strings.TrimSpace(mediatype))] {
+		return false
+	}
+
+	// the original's md5 keeps stale resized versions from being served
+	key := gfsFile.MD5()
+	if key == "" {
+		key = fmt.Sprint(gfsFile.Id())
+	}
+	name := fmt.Sprintf("%s/%dx%d", key, width, height)
+	etag := fileETag(fmt.Sprintf("%s-%dx%d", key, width, height))
+
+	w.Header().Set("ETag", etag)
+	if header := r.Header.Get("If-None-Match"); header != "" && etagMatch(header, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+
+	resized, err := m.resizedStore()
+	if err != nil {
+		m.srv.logError(w, err)
+		m.srv.writeError(w, r, "internal server error", http.StatusInternalServerError)
+		return true
+	}
+
+	var data []byte
+	cached, err := resized.Open(name)
+	if err == nil {
+		var buffer bytes.Buffer
+		_, err = buffer.ReadFrom(cached)
+		cached.Close()
+		data = buffer.Bytes()
+		ctype = cached.ContentType()
+	}
+	if err != nil {
+		data, ctype, err = resizeImage(gfsFile, width, height)
+		if err != nil {
+			m.srv.logError(w, err)
+			m.srv.writeError(w, r, "unable to resize image", http.StatusUnprocessableEntity)
+			return true
+		}
+		if err := storeResized(resized, name, ctype, data); err != nil {
+			m.srv.logError(w, err)
+		}
+	}
+
+	w.Header().Set("Content-Type", ctype)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	if r.Method != "HEAD" {
+		w.Write(data)
+	}
+
+	return true
+}
+
+// store of the resized images of the mount, the collection of the mount with .resized appended
+// being a bucket of its own keeps them out of listings, stats and file requests
+func (m *mount) resizedStore() (gfs gridStore, err error) {
+
+	mc := m.mountConfig
+	mc.GridFSCollection += ".resized"
+	_, gfs, err = m.srv.Stores.open(mc)
+
+	return
+}
+
+// decode, resize and encode the image in its own format
+// formats without an encoder are encoded as png
+// images over maxResizePixels are refused from their header, before any decoding
+func resizeImage(gfsFile gridFile, width int, height int) (data []byte, ctype string, err error) {
+
+	header, _, err := image.DecodeConfig(gfsFile)
+	if err != nil {
+		return
+	}
+	if int64(header.Width)*int64(header.Height) > maxResizePixels {
+		err = fmt.Errorf("image of %dx%d pixels is too large to resize", header.Width, header.Height)
+		return
+	}
+	if _, err = gfsFile.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+
+	src, format, err := image.Decode(gfsFile)
+	if err != nil {
+		return
+	}
+
+	dst := scaleImage(src, fitSize(src.Bounds().Dx(), src.Bounds().Dy(), width, height))
+
+	var buffer bytes.Buffer
+	switch format {
+	case "jpeg":
+		ctype = "image/jpeg"
+		err = jpeg.Encode(&buffer, dst, &jpeg.Options{Quality: 85})
+	case "gif":
+		ctype = "image/gif"
+		err = gif.Encode(&buffer, dst, nil)
+	default:
+		ctype = "image/png"
+		err = png.Encode(&buffer, dst)
+	}
+	data = buffer.Bytes()
+
+	return
+}
+
+// store a resized image in gridfs
+func storeResized(gfs gridStore, name string, ctype string, data []byte) (err error) {
+
+	gfsFile, err := gfs.Create(name)
+	if err != nil {
+		return
+	}
+	gfsFile.SetContentType(ctype)
+
+	_, err = gfsFile.Write(data)
+	if err != nil {
+		gfsFile.Abort()
+		gfsFile.Close()
+		return
+	}
+
+	return gfsFile.Close()
+}
+
+// size fitting into width and height keeping the aspect ratio
+// images are never scaled up
+func fitSize(srcWidth int, srcHeight int, width int, height int) image.Point {
+
+	if srcWidth == 0 || srcHeight == 0 {
+		return image.Point{srcWidth, srcHeight}
+	}
+	if width == 0 || width > srcWidth {
+		width = srcWidth
+	}
+	if height == 0 || height > srcHeight {
+		height = srcHeight
+	}
+
+	// the smaller scale wins
+	if width*srcHeight < height*srcWidth {
+		height = (srcHeight*width + srcWidth/2) / srcWidth
+	} else {
+		width = (srcWidth*height + srcHeight/2) / srcHeight
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	return image.Point{width, height}
+}
+
+// scale the image to the given size by averaging the covered source pixels
+func scaleImage(src image.Image, size image.Point) image.Image {
+
+	bounds := src.Bounds()
+	if bounds.Size() == size {
+		return src
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, size.X, size.Y))
+	for y := 0; y < size.Y; y++ {
+		y0 := y * srcHeight / size.Y
+		y1 := (y + 1) * srcHeight / size.Y
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < size.X; x++ {
+			x0 := x * srcWidth / size.X
+			x1 := (x + 1) * srcWidth / size.X
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					r += int(row[sx*4])
+					g += int(row[sx*4+1])
+					b += int(row[sx*4+2])
+					a += int(row[sx*4+3])
+					n++
+				}
+			}
+
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
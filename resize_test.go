@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"labix.org/v2/mgo/bson"
+)
+
+// png of a blank image of the given size
+func pngImage(t *testing.T, width int, height int) string {
+
+	var buffer bytes.Buffer
+	if err := png.Encode(&buffer, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+
+	return buffer.String()
+}
+
+func TestResizeImage(t *testing.T) {
+
+	conf := testConfig()
+	conf.EnableImageResize = true
+	stores := newMemStores()
+	s, err := newServer(conf, stores, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gfs := stores.bucket("test", "fs")
+	original := gfs.put("a.png", pngImage(t, 40, 20), "image/png", nil)
+
+	for i := 0; i < 2; i++ {
+		w := serve(s, httptest.NewRequest("GET", "/gridfs/a.png?w=10", nil))
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+			t.Fatalf("got %d %s", w.Code, w.Header().Get("Content-Type"))
+		}
+		config, err := png.DecodeConfig(w.Body)
+		if err != nil || config.Width != 10 || config.Height != 5 {
+			t.Fatalf("got %dx%d %v", config.Width, config.Height, err)
+		}
+	}
+
+	// the cache stays out of the served bucket
+	if names := stores.bucket("test", "fs.resized").names(); len(names) != 1 || names[0] != original.md5+"/10x0" {
+		t.Errorf("resized bucket has %v", names)
+	}
+	if names := gfs.names(); len(names) != 1 {
+		t.Errorf("served bucket has %v", names)
+	}
+}
+
+func TestResizeTooLargeImage(t *testing.T) {
+
+	conf := testConfig()
+	conf.EnableImageResize = true
+	s, gfs := newTestServer(t, conf)
+
+	// a gif claiming 65535x65535 pixels in its header
+	var buffer bytes.Buffer
+	if err := gif.Encode(&buffer, image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.Black, color.White}), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buffer.Bytes()
+	copy(data[6:10], []byte{0xff, 0xff, 0xff, 0xff})
+	gfs.put("huge.gif", string(data), "image/gif", nil)
+
+	w := serve(s, httptest.NewRequest("GET", "/gridfs/huge.gif?w=10", nil))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestResizeServesUnsupportedImages(t *testing.T) {
+
+	conf := testConfig()
+	conf.EnableImageResize = true
+	s, gfs := newTestServer(t, conf)
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="40" height="20"/>`
+	gfs.put("a.svg", svg, "image/svg+xml", nil)
+	gfs.put("a.webp", "RIFF....WEBP", "image/webp", nil)
+	original := pngImage(t, 40, 20)
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte(original))
+	gz.Close()
+	gfs.put("a.png", gzipped.String(), "image/png", bson.M{"gzip": true})
+
+	// no decoder for them, so the original is sent
+	for name, want := range map[string]string{"a.svg": svg, "a.webp": "RIFF....WEBP", "a.png": original} {
+		w := serve(s, httptest.NewRequest("GET", "/gridfs/"+name+"?w=10", nil))
+		if w.Code != http.StatusOK || w.Body.String() != want {
+			t.Errorf("%s: got %d with %d bytes", name, w.Code, w.Body.Len())
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/gridfs/a.png?w=10", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	if w := serve(s, r); w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" || w.Body.String() != gzipped.String() {
+		t.Errorf("gzip accepted: got %d %q with %d bytes", w.Code, w.Header().Get("Content-Encoding"), w.Body.Len())
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// counters of the runtime statistics, kept with atomics so collecting them costs no lock
+// unlike the metrics they are always collected
+type runtimeStats struct {
+	started     time.Time
+	requests    atomic.Uint64
+	byClass     [6]atomic.Uint64 // by status / 100, 1xx to 5xx
+	bytes       atomic.Uint64
+	inFlight    atomic.Int64
+	mongoErrors atomic.Uint64
+}
+
+// record a finished request
+func (rs *runtimeStats) observe(status int, bytes int64) {
+
+	rs.requests.Add(1)
+	if class := status / 100; class >= 1 && class <= 5 {
+		rs.byClass[class].Add(1)
+	}
+	rs.bytes.Add(uint64(bytes))
+}
+
+// record a failed mongodb operation for the metrics and the runtime statistics
+func (s *server) mongoError() {
+	s.Metrics.mongoError()
+	s.Runtime.mongoErrors.Add(1)
+}
+
+// handle requests for the runtime statistics as JSON
+func (s *server) runtimeHandler(w http.ResponseWriter, r *http.Request) {
+
+	classes := map[string]uint64{}
+	for class := 1; class <= 5; class++ {
+		classes[strconv.Itoa(class)+"xx"] = s.Runtime.byClass[class].Load()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"started":           s.Runtime.started,
+		"uptime_seconds":    time.Since(s.Runtime.started).Seconds(),
+		"requests":          s.Runtime.requests.Load(),
+		"requests_by_class": classes,
+		"bytes_served":      s.Runtime.bytes.Load(),
+		"in_flight":         s.Runtime.inFlight.Load(),
+		"mongo_errors":      s.Runtime.mongoErrors.Load(),
+	})
+	if err != nil {
+		s.logError(w, err)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+var errInvalidPath = errors.New("invalid path")
+
+// clean the requested path before it is used for a lookup
+// leading, duplicate and "." segments are dropped, a trailing slash is kept
+// paths containing ".." segments or null bytes are rejected
+func cleanPath(path string) (cleaned string, err error) {
+
+	if strings.ContainsRune(path, 0) {
+		return "", errInvalidPath
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(path, "/") {
+		switch segment {
+		case "", ".":
+			continue
+		case "..":
+			return "", errInvalidPath
+		}
+		// windows style separators must not sneak in a traversal
+		for _, part := range strings.Split(segment, `\`) {
+			if part == ".." {
+				return "", errInvalidPath
+			}
+		}
+		segments = append(segments, segment)
+	}
+
+	cleaned = strings.Join(segments, "/")
+	if cleaned != "" && strings.HasSuffix(path, "/") {
+		cleaned += "/"
+	}
+
+	return
+}
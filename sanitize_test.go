@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCleanPath(t *testing.T) {
+
+	for path, want := range map[string]string{
+		"a.txt":         "a.txt",
+		"/a.txt":        "a.txt",
+		"//docs//a.txt": "docs/a.txt",
+		"./docs/./a":    "docs/a",
+		"docs/":         "docs/",
+		"docs/..a":      "docs/..a",
+		"":              "",
+		"/":             "",
+	} {
+		if got, err := cleanPath(path); err != nil || got != want {
+			t.Errorf("%q: got %q %v, want %q", path, got, err, want)
+		}
+	}
+
+	for _, path := range []string{"..", "../etc/passwd", "docs/../../a", `docs\..\a`, "a\x00.txt"} {
+		if got, err := cleanPath(path); err != errInvalidPath {
+			t.Errorf("%q: got %q %v", path, got, err)
+		}
+	}
+}
+
+func TestServeInvalidPaths(t *testing.T) {
+
+	s, gfs := newTestServer(t, testConfig())
+	gfs.put("docs/a.txt", "a", "text/plain", nil)
+
+	for path, want := range map[string]int{
+		"/gridfs/docs/a.txt":         http.StatusOK,
+		"/gridfs/docs/%2e%2e/a.txt":  http.StatusBadRequest,
+		"/gridfs/docs%2F..%2Fa.txt":  http.StatusBadRequest,
+		"/gridfs/docs/%5C..%5Ca.txt": http.StatusBadRequest,
+		"/gridfs/a%00.txt":           http.StatusBadRequest,
+	} {
+		if w := serve(s, httptest.NewRequest("GET", path, nil)); w.Code != want {
+			t.Errorf("%s: got %d %q, want %d", path, w.Code, w.Body.String(), want)
+		}
+	}
+}
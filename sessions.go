@@ -0,0 +1,170 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+
+	"labix.org/v2/mgo"
+)
+
+// source of the gridfs stores of the mounts
+// newServer takes one, so handlers can be built on stores other than mongodb
+type storeOpener interface {
+	// stores of a mount for reads and for uploads and deletes
+	open(mc mountConfig) (gfs gridStore, write gridStore, err error)
+	// check that every connection is up
+	ping() error
+	// apply a reloaded mode to the connections following the top level one
+	setMode(mode mgo.Mode)
+	close()
+}
+
+// connection string of a connection config, the mongouri replacing the servers
+func connection(servers []string, uri string) string {
+
+	if uri != "" {
+		return uri
+	}
+
+	return strings.Join(servers, ",")
+}
+
+// mgo modes a mount may use
+var mountModes = map[string]mgo.Mode{
+	"strong":    mgo.Strong,
+	"monotonic": mgo.Monotonic,
+	"eventual":  mgo.Eventual,
+}
+
+// gridfs stores on mongodb
+// mounts with their own servers or mongouri share one session per distinct connection,
+// all others use the top level one, mounts with their own mode share a copy in that mode
+// credentials, tls and timeouts are those of the top level config
+type mongoStores struct {
+	logger  *log.Logger
+	conf    config
+	session *mgo.Session            // top level, closed by its owner
+	dialed  map[string]*mgo.Session // by connection string
+	copies  map[string]*mgo.Session // by connection string, mode and writes
+	lock    sync.Mutex
+}
+
+func newMongoStores(conf config, mgo_session *mgo.Session, logger *log.Logger) *mongoStores {
+	return &mongoStores{
+		logger:  logger,
+		conf:    conf,
+		session: mgo_session,
+		dialed:  map[string]*mgo.Session{},
+		copies:  map[string]*mgo.Session{},
+	}
+}
+
+func (ms *mongoStores) open(mc mountConfig) (gfs gridStore, write gridStore, err error) {
+
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	read := ms.session
+	key := connection(mc.Servers, mc.MongoURI)
+	if key == "" || key == connection(ms.conf.Servers, ms.conf.MongoURI) {
+		key = ""
+	} else if read = ms.dialed[key]; read == nil {
+		dialed := ms.conf
+		dialed.Servers, dialed.MongoURI = mc.Servers, mc.MongoURI
+		read, err = waitForMongo(ms.logger, dialed)
+		if err != nil {
+			return
+		}
+		if mc.MongoURI != "" {
+			ms.logger.Println("mount", mc.HandlePath, "connected to", redactURI(key))
+		} else {
+			ms.logger.Println("mount", mc.HandlePath, "connected to", key)
+		}
+		ms.dialed[key] = read
+	}
+
+	if mode, ok := mountModes[strings.ToLower(mc.Mode)]; ok {
+		key += "\x00" + strings.ToLower(mc.Mode)
+		read = ms.copy(key, read, mode)
+	}
+
+	// uploads and deletes go to the primary while reads follow mode
+	writeSession := read
+	if ms.conf.StrongWrites {
+		writeSession = ms.copy(key+"\x00write", read, mgo.Strong)
+	}
+
+	gfs = mgoStore{read.DB(mc.Database).GridFS(mc.GridFSCollection)}
+	write = mgoStore{writeSession.DB(mc.Database).GridFS(mc.GridFSCollection)}
+
+	return
+}
+
+// copy of a session in another mode, made once per key
+func (ms *mongoStores) copy(key string, session *mgo.Session, mode mgo.Mode) *mgo.Session {
+
+	copied := ms.copies[key]
+	if copied == nil {
+		copied = session.Copy()
+		copied.SetMode(mode, true)
+		ms.copies[key] = copied
+	}
+
+	return copied
+}
+
+// ping the top level connection and those dialed for mounts
+func (ms *mongoStores) ping() (err error) {
+
+	ms.lock.Lock()
+	sessions := []*mgo.Session{ms.session}
+	for _, session := range ms.dialed {
+		sessions = append(sessions, session)
+	}
+	ms.lock.Unlock()
+
+	for _, session := range sessions {
+		if err = session.Ping(); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// the copies keep their own mode
+func (ms *mongoStores) setMode(mode mgo.Mode) {
+
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	ms.session.SetMode(mode, true)
+	for _, session := range ms.dialed {
+		session.SetMode(mode, true)
+	}
+}
+
+// close the sessions opened for mounts, the top level one is closed by its owner
+func (ms *mongoStores) close() {
+
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	for _, session := range ms.copies {
+		session.Close()
+	}
+	for _, session := range ms.dialed {
+		session.Close()
+	}
+}
+
+// session a gridfs belongs to, nil for stores not on mongodb
+func sessionOf(gfs gridStore) *mgo.Session {
+
+	if gfs, ok := gfs.(mgoStore); ok {
+		return gfs.Files.Database.Session
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signed url of the target valid for ttl
+func sign(t *testing.T, secret string, target string, ttl time.Duration) string {
+
+	signed, err := signURL(secret, target, time.Now().Add(ttl))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+func TestSignedURLs(t *testing.T) {
+
+	conf := testConfig()
+	conf.AuthUser, conf.AuthPass = "user", "pass"
+	conf.SigningSecret = "secret"
+	conf.AllowUpload, conf.AllowDelete = true, true
+	s, gfs := newTestServer(t, conf)
+	gfs.put("a.txt", "content", "text/plain", nil)
+
+	signed := sign(t, conf.SigningSecret, "/gridfs/a.txt", time.Hour)
+	expired := sign(t, conf.SigningSecret, "/gridfs/a.txt", -time.Hour)
+	tampered := strings.Replace(signed, "a.txt", "b.txt", 1)
+
+	tests := []struct {
+		method string
+		url    string
+		status int
+	}{
+		{"GET", signed, http.StatusOK},
+		{"HEAD", signed, http.StatusOK},
+		{"GET", expired, http.StatusForbidden},
+		{"GET", tampered, http.StatusForbidden},
+		{"GET", "/gridfs/a.txt", http.StatusUnauthorized},
+		// a download link grants no writes
+		{"PUT", signed, http.StatusUnauthorized},
+		{"DELETE", signed, http.StatusUnauthorized},
+		{"POST", signed, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		w := serve(s, httptest.NewRequest(test.method, test.url, strings.NewReader("overwritten")))
+		if w.Code != test.status {
+			t.Errorf("%s %s: got %d, want %d", test.method, test.url, w.Code, test.status)
+		}
+	}
+	if names := gfs.names(); len(names) != 1 || string(gfs.files[0].content) != "content" {
+		t.Errorf("stored files changed: %v", names)
+	}
+}
+
+func TestSignedModifiers(t *testing.T) {
+
+	conf := testConfig()
+	conf.AuthUser, conf.AuthPass = "user", "pass"
+	conf.SigningSecret = "secret"
+	conf.AllowedDatabases = []string{"other"}
+	s, gfs := newTestServer(t, conf)
+	gfs.put("a.txt", "first", "text/plain", nil)
+	gfs.put("a.txt", "second", "text/plain", nil)
+	s.Stores.(*memStores).bucket("other", "fs").put("a.txt", "other tenant", "text/plain", nil)
+
+	signed := sign(t, conf.SigningSecret, "/gridfs/a.txt?version=0", time.Hour)
+	if w := serve(s, httptest.NewRequest("GET", signed, nil)); w.Code != http.StatusOK || w.Body.String() != "first" {
+		t.Fatalf("signed version: got %d %q", w.Code, w.Body.String())
+	}
+
+	// modifiers are part of the signature
+	for _, url := range []string{
+		strings.Replace(signed, "version=0", "version=1", 1),
+		signed + "&by=id",
+		signed + "&w=10",
+	} {
+		if w := serve(s, httptest.NewRequest("GET", url, nil)); w.Code != http.StatusForbidden {
+			t.Errorf("%s: got %d", url, w.Code)
+		}
+	}
+
+	// nor can a link be replayed against another database
+	r := httptest.NewRequest("GET", signed, nil)
+	r.Header.Set("X-Database", "other")
+	if w := serve(s, r); w.Code != http.StatusForbidden {
+		t.Errorf("X-Database: got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestSignedURLBelowStripPrefix(t *testing.T) {
+
+	conf := testConfig()
+	conf.AuthUser, conf.AuthPass = "user", "pass"
+	conf.SigningSecret = "secret"
+	conf.StripPrefix = "/assets"
+	s, gfs := newTestServer(t, conf)
+	gfs.put("a.txt", "content", "text/plain", nil)
+
+	// links are signed for the public path the client requests
+	signed := sign(t, conf.SigningSecret, "/assets/gridfs/a.txt", time.Hour)
+	if w := serve(s, httptest.NewRequest("GET", signed, nil)); w.Code != http.StatusOK || w.Body.String() != "content" {
+		t.Errorf("public path: got %d %q", w.Code, w.Body.String())
+	}
+	stripped := sign(t, conf.SigningSecret, "/gridfs/a.txt", time.Hour)
+	if w := serve(s, httptest.NewRequest("GET", "/assets"+stripped, nil)); w.Code != http.StatusForbidden {
+		t.Errorf("stripped path: got %d", w.Code)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"labix.org/v2/mgo"
+)
+
+// handler answering requests while mongodb isn't connected yet
+// health checks get "starting", everything else 503, until ready
+// passes requests on to the real routes
+type startupHandler struct {
+	healthPath string
+	lock       sync.RWMutex
+	next       http.Handler
+}
+
+func newStartupHandler(healthPath string) *startupHandler {
+	return &startupHandler{healthPath: healthPath}
+}
+
+// serve the routes from now on
+func (sh *startupHandler) ready(next http.Handler) {
+	sh.lock.Lock()
+	sh.next = next
+	sh.lock.Unlock()
+}
+
+func (sh *startupHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	sh.lock.RLock()
+	next := sh.next
+	sh.lock.RUnlock()
+
+	if next != nil {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("Retry-After", "1")
+	if sh.healthPath != "" && r.URL.Path == sh.healthPath {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("starting"))
+		return
+	}
+	http.Error(w, "starting", http.StatusServiceUnavailable)
+}
+
+// connect to mongodb and ping it, retrying with backoff for up to
+// startuptimeout seconds, 0 tries once, failed logins aren't retried
+func waitForMongo(logger *log.Logger, conf config) (mgo_session *mgo.Session, err error) {
+
+	deadline := time.Now().Add(time.Duration(conf.StartupTimeout) * time.Second)
+	backoff := 500 * time.Millisecond
+
+	for {
+		mgo_session, err = dialMongo(logger, conf)
+		if err == nil {
+			err = mgo_session.Ping()
+			if err == nil {
+				return
+			}
+			mgo_session.Close()
+		}
+
+		if errors.Is(err, errMongoAuth) || time.Now().Add(backoff).After(deadline) {
+			return nil, err
+		}
+		logger.Println("mongodb not ready:", err, "retrying in", backoff)
+		time.Sleep(backoff)
+		if backoff < 5*time.Second {
+			backoff *= 2
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+)
+
+// stores other than mongodb can't aggregate
+var errStatsUnsupported = errors.New("storage statistics need a mongodb gridfs")
+
+// default seconds storage statistics are cached
+const defaultStatsTTL = 60
+
+// storage statistics of a mount's gridfs collection
+type mountStats struct {
+	HandlePath       string     `json:"handlepath"`
+	Database         string     `json:"database"`
+	GridFSCollection string     `json:"gridfscollection"`
+	Files            int        `json:"files"`
+	Bytes            int64      `json:"bytes"`
+	Largest          *listEntry `json:"largest,omitempty"`
+	Newest           *listEntry `json:"newest,omitempty"`
+}
+
+// storage statistics cached between requests
+type statsCache struct {
+	lock    sync.Mutex
+	stats   []mountStats
+	expires time.Time
+}
+
+// aggregate the files collection of a mount
+func (m *mount) stats() (stats mountStats, err error) {
+
+	stats = mountStats{HandlePath: m.HandlePath, Database: m.Database, GridFSCollection: m.GridFSCollection}
+	gfs, ok := m.GFS.(mgoStore)
+	if !ok {
+		err = errStatsUnsupported
+		return
+	}
+	files := gfs.Files
+
+	var total struct {
+		Files int   `bson:"files"`
+		Bytes int64 `bson:"bytes"`
+	}
+	err = files.Pipe([]bson.M{
+		{"$group": bson.M{"_id": nil, "files": bson.M{"$sum": 1}, "bytes": bson.M{"$sum": "$length"}}},
+	}).One(&total)
+	if err != nil {
+		// an empty collection has nothing to group
+		if err == mgo.ErrNotFound {
+			err = nil
+		}
+		return
+	}
+	stats.Files, stats.Bytes = total.Files, total.Bytes
+
+	var largest, newest listEntry
+	if err = files.Find(nil).Sort("-length").One(&largest); err != nil {
+		return
+	}
+	if err = files.Find(nil).Sort("-uploadDate").One(&newest); err != nil {
+		return
+	}
+	stats.Largest, stats.Newest = &largest, &newest
+
+	return
+}
+
+// handle requests for the storage statistics of all mounts
+// the aggregation is expensive on large collections, so results are cached for statsttl seconds
+// and requests go through the rate limit, maintenance mode and authentication of file requests
+func (s *server) statsHandler(w http.ResponseWriter, r *http.Request) {
+
+	conf := s.conf()
+
+	if !s.checkRateLimit(w, r) {
+		return
+	}
+	if !s.checkMaintenance(w, r, conf) {
+		return
+	}
+	if !s.checkAuth(w, r, conf) {
+		return
+	}
+
+	ttl := conf.StatsTTL
+	if ttl <= 0 {
+		ttl = defaultStatsTTL
+	}
+
+	s.Stats.lock.Lock()
+	defer s.Stats.lock.Unlock()
+
+	if time.Now().After(s.Stats.expires) {
+		var all []mountStats
+		for _, m := range s.Mounts {
+			stats, err := m.stats()
+			if err != nil {
+				s.mongoError()
+				s.logError(w, err)
+				s.writeError(w, r, "internal server error", http.StatusInternalServerError)
+				return
+			}
+			all = append(all, stats)
+		}
+		s.Stats.stats = all
+		s.Stats.expires = time.Now().Add(time.Duration(ttl) * time.Second)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(s.Stats.stats)
+	if err != nil {
+		s.logError(w, err)
+	}
+}
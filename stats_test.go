@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStatsChecks(t *testing.T) {
+
+	conf := testConfig()
+	conf.StatsPath = "/stats"
+	conf.AuthUser, conf.AuthPass = "ops", "secret"
+	s, _ := newTestServer(t, conf)
+
+	if w := serve(s, httptest.NewRequest("GET", "/stats", nil)); w.Code != http.StatusUnauthorized {
+		t.Errorf("without credentials: got %d", w.Code)
+	}
+
+	// memory stores can't aggregate, getting that far means the checks passed
+	r := httptest.NewRequest("GET", "/stats", nil)
+	r.SetBasicAuth("ops", "secret")
+	if w := serve(s, r); w.Code != http.StatusInternalServerError {
+		t.Errorf("with credentials: got %d", w.Code)
+	}
+
+	conf.Maintenance = true
+	s, _ = newTestServer(t, conf)
+	r = httptest.NewRequest("GET", "/stats", nil)
+	r.SetBasicAuth("ops", "secret")
+	if w := serve(s, r); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("in maintenance: got %d", w.Code)
+	}
+
+	conf = testConfig()
+	conf.StatsPath = "/stats"
+	conf.RateLimitRPS, conf.RateLimitBurst = 0.001, 1
+	s, _ = newTestServer(t, conf)
+	serve(s, httptest.NewRequest("GET", "/stats", nil))
+	if w := serve(s, httptest.NewRequest("GET", "/stats", nil)); w.Code != http.StatusTooManyRequests {
+		t.Errorf("over the rate limit: got %d", w.Code)
+	}
+}
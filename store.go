@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io"
+	"time"
+
+	"labix.org/v2/mgo"
+)
+
+// gridfs operations the handlers depend on
+// mgoStore wraps *mgo.GridFS to satisfy it, other implementations can stand in for it
+type gridStore interface {
+	Open(name string) (gridFile, error)
+	OpenId(id interface{}) (gridFile, error)
+	Create(name string) (gridFile, error)
+	Find(query interface{}) gridQuery
+	Remove(name string) error
+	RemoveId(id interface{}) error
+}
+
+// a stored file opened for reading or a new one being written
+// *mgo.GridFile satisfies it
+type gridFile interface {
+	io.Reader
+	io.Writer
+	io.Seeker
+	Close() error
+	Abort()
+	Id() interface{}
+	SetId(id interface{})
+	Name() string
+	Size() int64
+	ContentType() string
+	SetContentType(ctype string)
+	UploadDate() time.Time
+	MD5() string
+	GetMeta(result interface{}) error
+	SetMeta(metadata interface{})
+}
+
+// query of the files collection of a gridfs
+type gridQuery interface {
+	Select(selector interface{}) gridQuery
+	Sort(fields ...string) gridQuery
+	Skip(n int) gridQuery
+	Limit(n int) gridQuery
+	One(result interface{}) error
+	All(result interface{}) error
+	Iter() gridIter
+}
+
+// iterator over query results, *mgo.Iter satisfies it
+type gridIter interface {
+	Next(result interface{}) bool
+	Close() error
+}
+
+var _ gridFile = (*mgo.GridFile)(nil)
+var _ gridIter = (*mgo.Iter)(nil)
+
+// gridStore of a mongodb gridfs
+type mgoStore struct {
+	*mgo.GridFS
+}
+
+// files are returned as untyped nils on errors, so callers can compare them to nil
+func (gfs mgoStore) Open(name string) (gridFile, error) {
+	file, err := gfs.GridFS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (gfs mgoStore) OpenId(id interface{}) (gridFile, error) {
+	file, err := gfs.GridFS.OpenId(id)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (gfs mgoStore) Create(name string) (gridFile, error) {
+	file, err := gfs.GridFS.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (gfs mgoStore) Find(query interface{}) gridQuery {
+	return mgoQuery{gfs.GridFS.Find(query)}
+}
+
+// gridQuery of an mgo query
+type mgoQuery struct {
+	*mgo.Query
+}
+
+func (q mgoQuery) Select(selector interface{}) gridQuery {
+	return mgoQuery{q.Query.Select(selector)}
+}
+
+func (q mgoQuery) Sort(fields ...string) gridQuery {
+	return mgoQuery{q.Query.Sort(fields...)}
+}
+
+func (q mgoQuery) Skip(n int) gridQuery {
+	return mgoQuery{q.Query.Skip(n)}
+}
+
+func (q mgoQuery) Limit(n int) gridQuery {
+	return mgoQuery{q.Query.Limit(n)}
+}
+
+func (q mgoQuery) Iter() gridIter {
+	return q.Query.Iter()
+}
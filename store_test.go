@@ -0,0 +1,519 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+)
+
+// in-memory gridStore for tests
+// queries support equality, $ne and regular expressions on the top level fields and metadata
+type memStore struct {
+	lock  sync.Mutex
+	files []*memFile
+	now   time.Time
+	// bytes read from all opened files
+	read atomic.Int64
+	// files opened and queries run
+	lookups atomic.Int64
+	// error of every Open and OpenId, if set
+	openErr error
+}
+
+var _ gridStore = (*memStore)(nil)
+
+// stored file, or one being written until it is closed
+type memFile struct {
+	store      *memStore
+	id         interface{}
+	name       string
+	ctype      string
+	uploadDate time.Time
+	md5        string
+	meta       interface{}
+	content    []byte
+	reader     *bytes.Reader
+	writing    bool
+	aborted    bool
+	// fails reads once this many bytes were read, if set
+	failAfter int
+	// time every read takes
+	delay time.Duration
+}
+
+func newMemStore() *memStore {
+	return &memStore{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+// store a file with the given content, later calls store newer files
+func (s *memStore) put(name string, content string, ctype string, meta bson.M) *memFile {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.now = s.now.Add(time.Second)
+	sum := md5.Sum([]byte(content))
+	f := &memFile{
+		store:      s,
+		id:         bson.NewObjectId(),
+		name:       name,
+		ctype:      ctype,
+		uploadDate: s.now,
+		md5:        hex.EncodeToString(sum[:]),
+		content:    []byte(content),
+	}
+	if meta != nil {
+		f.meta = meta
+	}
+	s.files = append(s.files, f)
+
+	return f
+}
+
+// opened copy of a stored file
+func (f *memFile) open() *memFile {
+	opened := *f
+	opened.reader = bytes.NewReader(f.content)
+	return &opened
+}
+
+func (s *memStore) Open(name string) (gridFile, error) {
+
+	s.lookups.Add(1)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.openErr != nil {
+		return nil, s.openErr
+	}
+	var newest *memFile
+	for _, f := range s.files {
+		if f.name == name && (newest == nil || !f.uploadDate.Before(newest.uploadDate)) {
+			newest = f
+		}
+	}
+	if newest == nil {
+		return nil, mgo.ErrNotFound
+	}
+
+	return newest.open(), nil
+}
+
+func (s *memStore) OpenId(id interface{}) (gridFile, error) {
+
+	s.lookups.Add(1)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.openErr != nil {
+		return nil, s.openErr
+	}
+	for _, f := range s.files {
+		if f.id == id {
+			return f.open(), nil
+		}
+	}
+
+	return nil, mgo.ErrNotFound
+}
+
+func (s *memStore) Create(name string) (gridFile, error) {
+	return &memFile{store: s, id: bson.NewObjectId(), name: name, writing: true}, nil
+}
+
+func (s *memStore) Remove(name string) error {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	kept := s.files[:0]
+	for _, f := range s.files {
+		if f.name != name {
+			kept = append(kept, f)
+		}
+	}
+	s.files = kept
+
+	return nil
+}
+
+func (s *memStore) RemoveId(id interface{}) error {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for i, f := range s.files {
+		if f.id == id {
+			s.files = append(s.files[:i], s.files[i+1:]...)
+			return nil
+		}
+	}
+
+	return mgo.ErrNotFound
+}
+
+// stored file by id, nil if there is none
+func (s *memStore) get(id interface{}) *memFile {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, f := range s.files {
+		if f.id == id {
+			return f
+		}
+	}
+
+	return nil
+}
+
+// names of the stored files in upload order
+func (s *memStore) names() (names []string) {
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, f := range s.files {
+		names = append(names, f.name)
+	}
+
+	return
+}
+
+func (f *memFile) Read(p []byte) (int, error) {
+
+	time.Sleep(f.delay)
+
+	if f.failAfter > 0 {
+		read := int(f.reader.Size()) - f.reader.Len()
+		if read >= f.failAfter {
+			return 0, errors.New("chunk read failed")
+		}
+		if len(p) > f.failAfter-read {
+			p = p[:f.failAfter-read]
+		}
+	}
+
+	n, err := f.reader.Read(p)
+	f.store.read.Add(int64(n))
+
+	return n, err
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.content = append(f.content, p...)
+	return len(p), nil
+}
+
+func (f *memFile) Seek(offset int64, whence int) (int64, error) {
+	return f.reader.Seek(offset, whence)
+}
+
+// written files are stored once they are closed, unless aborted
+// ids of stored files can't be reused, like with the unique index of mongodb
+func (f *memFile) Close() error {
+
+	if !f.writing {
+		return nil
+	}
+	f.writing = false
+	if f.aborted {
+		return errors.New("write aborted")
+	}
+
+	s := f.store
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, stored := range s.files {
+		if stored.id == f.id {
+			return fmt.Errorf("E11000 duplicate key error: _id %v", f.id)
+		}
+	}
+	s.now = s.now.Add(time.Second)
+	sum := md5.Sum(f.content)
+	f.uploadDate, f.md5 = s.now, hex.EncodeToString(sum[:])
+	s.files = append(s.files, f)
+
+	return nil
+}
+
+func (f *memFile) Abort()                           { f.aborted = true }
+func (f *memFile) Id() interface{}                  { return f.id }
+func (f *memFile) SetId(id interface{})             { f.id = id }
+func (f *memFile) Name() string                     { return f.name }
+func (f *memFile) Size() int64                      { return int64(len(f.content)) }
+func (f *memFile) ContentType() string              { return f.ctype }
+func (f *memFile) SetContentType(ctype string)      { f.ctype = ctype }
+func (f *memFile) UploadDate() time.Time            { return f.uploadDate }
+func (f *memFile) MD5() string                      { return f.md5 }
+func (f *memFile) SetMeta(metadata interface{})     { f.meta = metadata }
+func (f *memFile) GetMeta(result interface{}) error { return convert(f.meta, result) }
+
+// files collection document of the file
+func (f *memFile) doc() bson.M {
+	return bson.M{
+		"_id":         f.id,
+		"filename":    f.name,
+		"contentType": f.ctype,
+		"length":      int64(len(f.content)),
+		"uploadDate":  f.uploadDate,
+		"md5":         f.md5,
+		"metadata":    f.meta,
+	}
+}
+
+// decode a value into result through bson, like mgo does
+func convert(value interface{}, result interface{}) error {
+
+	if value == nil {
+		return nil
+	}
+	data, err := bson.Marshal(bson.M{"v": value})
+	if err != nil {
+		return err
+	}
+	var raw struct {
+		V bson.Raw `bson:"v"`
+	}
+	if err = bson.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	return raw.V.Unmarshal(result)
+}
+
+func (s *memStore) Find(query interface{}) gridQuery {
+	s.lookups.Add(1)
+	filter, _ := query.(bson.M)
+	return &memQuery{store: s, filter: filter}
+}
+
+// query of a memStore
+type memQuery struct {
+	store  *memStore
+	filter bson.M
+	sort   []string
+	skip   int
+	limit  int
+}
+
+func (q *memQuery) Select(selector interface{}) gridQuery { return q }
+func (q *memQuery) Sort(fields ...string) gridQuery       { q.sort = fields; return q }
+func (q *memQuery) Skip(n int) gridQuery                  { q.skip = n; return q }
+func (q *memQuery) Limit(n int) gridQuery                 { q.limit = n; return q }
+
+// documents matching the filter in the order of the query
+func (q *memQuery) docs() (docs []bson.M) {
+
+	q.store.lock.Lock()
+	for _, f := range q.store.files {
+		doc := f.doc()
+		if matches(doc, q.filter) {
+			docs = append(docs, doc)
+		}
+	}
+	q.store.lock.Unlock()
+
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, field := range q.sort {
+			desc := strings.HasPrefix(field, "-")
+			field = strings.TrimPrefix(field, "-")
+			a, b := fmt.Sprint(lookupField(docs[i], field)), fmt.Sprint(lookupField(docs[j], field))
+			if ta, ok := lookupField(docs[i], field).(time.Time); ok {
+				a, b = ta.Format(time.RFC3339Nano), lookupField(docs[j], field).(time.Time).Format(time.RFC3339Nano)
+			}
+			if na, ok := lookupField(docs[i], field).(int64); ok {
+				a, b = fmt.Sprintf("%020d", na), fmt.Sprintf("%020d", lookupField(docs[j], field).(int64))
+			}
+			if a != b {
+				return (a < b) != desc
+			}
+		}
+		return false
+	})
+
+	if q.skip >= len(docs) {
+		return nil
+	}
+	docs = docs[q.skip:]
+	if q.limit > 0 && len(docs) > q.limit {
+		docs = docs[:q.limit]
+	}
+
+	return
+}
+
+// value of a dotted field like metadata.sku
+func lookupField(doc bson.M, field string) interface{} {
+
+	var value interface{} = doc
+	for _, key := range strings.Split(field, ".") {
+		m, ok := value.(bson.M)
+		if !ok {
+			return nil
+		}
+		value = m[key]
+	}
+
+	return value
+}
+
+func matches(doc bson.M, filter bson.M) bool {
+
+	for field, want := range filter {
+		value := lookupField(doc, field)
+		switch want := want.(type) {
+		case bson.M:
+			if ne, ok := want["$ne"]; ok && reflect.DeepEqual(value, ne) {
+				return false
+			}
+		case bson.RegEx:
+			name, _ := value.(string)
+			if !regexp.MustCompile(want.Pattern).MatchString(name) {
+				return false
+			}
+		default:
+			if !reflect.DeepEqual(value, want) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (q *memQuery) One(result interface{}) error {
+
+	docs := q.docs()
+	if len(docs) == 0 {
+		return mgo.ErrNotFound
+	}
+
+	return convert(docs[0], result)
+}
+
+func (q *memQuery) All(result interface{}) error {
+
+	docs := q.docs()
+	if docs == nil {
+		docs = []bson.M{}
+	}
+
+	return convert(docs, result)
+}
+
+func (q *memQuery) Iter() gridIter {
+	return &memIter{docs: q.docs()}
+}
+
+// iterator of memQuery results
+type memIter struct {
+	docs []bson.M
+}
+
+func (it *memIter) Next(result interface{}) bool {
+
+	if len(it.docs) == 0 {
+		return false
+	}
+	err := convert(it.docs[0], result)
+	it.docs = it.docs[1:]
+
+	return err == nil
+}
+
+func (it *memIter) Close() error {
+	return nil
+}
+
+func TestOpenFileByField(t *testing.T) {
+
+	gfs := newMemStore()
+	old := gfs.put("a.txt", "old", "text/plain", nil)
+	newest := gfs.put("a.txt", "new", "text/plain", bson.M{"sku": "123"})
+	ctx := context.Background()
+
+	tests := []struct {
+		value   string
+		field   string
+		version int
+		want    *memFile
+	}{
+		{"a.txt", "filename", -1, newest},
+		{"a.txt", "filename", 0, old},
+		{"a.txt", "filename", -2, old},
+		{"123", "metadata.sku", -1, newest},
+		{"b.txt", "filename", -1, nil},
+		{"a.txt", "filename", 2, nil},
+		{"456", "metadata.sku", -1, nil},
+	}
+	for _, test := range tests {
+		gfsFile, err := openFile(ctx, gfs, test.value, test.field, test.version)
+		if test.want == nil {
+			if err != mgo.ErrNotFound {
+				t.Errorf("%s by %s version %d: got %v, want not found", test.value, test.field, test.version, err)
+			}
+			continue
+		}
+		if err != nil || gfsFile.Id() != test.want.id {
+			t.Errorf("%s by %s version %d: got %v %v", test.value, test.field, test.version, gfsFile, err)
+		}
+	}
+
+	named := gfs.put("b.txt", "b", "", nil)
+	named.id = "logo-1"
+	gfsFile, err := openFile(ctx, gfs, "logo-1", "_id", -1)
+	if err != nil || gfsFile.Id() != named.id {
+		t.Errorf("by _id: got %v %v", gfsFile, err)
+	}
+}
+
+// storeOpener of memStores by database and collection
+type memStores struct {
+	lock    sync.Mutex
+	buckets map[string]*memStore
+}
+
+var _ storeOpener = (*memStores)(nil)
+
+func newMemStores() *memStores {
+	return &memStores{buckets: map[string]*memStore{}}
+}
+
+// store of a database and collection, created on first use
+func (ms *memStores) bucket(database string, collection string) *memStore {
+
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	key := database + "." + collection
+	if ms.buckets[key] == nil {
+		ms.buckets[key] = newMemStore()
+	}
+
+	return ms.buckets[key]
+}
+
+func (ms *memStores) open(mc mountConfig) (gfs gridStore, write gridStore, err error) {
+	gfs = ms.bucket(mc.Database, mc.GridFSCollection)
+	return gfs, gfs, nil
+}
+
+func (ms *memStores) ping() error           { return nil }
+func (ms *memStores) setMode(mode mgo.Mode) {}
+func (ms *memStores) close()                {}
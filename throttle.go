@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"io"
+	"math"
+	"time"
+)
+
+// writer limited to a number of bytes per second by a token bucket
+// holding up to one second of bytes, waits sleep and end with the context
+type throttledWriter struct {
+	ctx    context.Context
+	w      io.Writer
+	rate   float64 // bytes per second
+	tokens float64
+	last   time.Time
+}
+
+func newThrottledWriter(ctx context.Context, w io.Writer, rate int64) *throttledWriter {
+	return &throttledWriter{ctx: ctx, w: w, rate: float64(rate), tokens: float64(rate), last: time.Now()}
+}
+
+func (tw *throttledWriter) Write(b []byte) (written int, err error) {
+
+	for len(b) > 0 {
+		chunk := b
+		if float64(len(chunk)) > tw.rate {
+			chunk = chunk[:int(tw.rate)]
+		}
+
+		// refill since the last write and wait for the missing tokens
+		now := time.Now()
+		tw.tokens = math.Min(tw.rate, tw.tokens+now.Sub(tw.last).Seconds()*tw.rate)
+		tw.last = now
+		if missing := float64(len(chunk)) - tw.tokens; missing > 0 {
+			timer := time.NewTimer(time.Duration(missing / tw.rate * float64(time.Second)))
+			select {
+			case <-tw.ctx.Done():
+				timer.Stop()
+				return written, tw.ctx.Err()
+			case <-timer.C:
+			}
+			tw.tokens += missing
+			tw.last = time.Now()
+		}
+
+		n, err := tw.w.Write(chunk)
+		written += n
+		tw.tokens -= float64(n)
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+
+	return
+}
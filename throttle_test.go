@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestThrottledDownload(t *testing.T) {
+
+	conf := testConfig()
+	conf.MaxBytesPerSecond = 1000
+	s, gfs := newTestServer(t, conf)
+	gfs.put("a.bin", strings.Repeat("x", 1500), "application/octet-stream", nil)
+
+	// the first second of bytes is in the bucket, the rest takes half a second
+	start := time.Now()
+	w := serve(s, httptest.NewRequest("GET", "/gridfs/a.bin", nil))
+	if elapsed := time.Since(start); elapsed < 450*time.Millisecond {
+		t.Errorf("took %s", elapsed)
+	}
+	if w.Code != http.StatusOK || w.Body.Len() != 1500 {
+		t.Errorf("got %d with %d bytes", w.Code, w.Body.Len())
+	}
+}
+
+func TestThrottleEndsWithContext(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var out bytes.Buffer
+	tw := newThrottledWriter(ctx, &out, 10)
+	start := time.Now()
+	written, err := tw.Write(make([]byte, 100))
+	if err != context.DeadlineExceeded || written != 10 {
+		t.Errorf("got %d %v", written, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("waited %s for a done context", elapsed)
+	}
+}
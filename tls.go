@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// load the certificate and key pair for serving https
+// http/2 is offered first, http/1.1 remains for older clients
+func loadTLSConfig(certFile string, keyFile string) (tlsConfig *tls.Config, err error) {
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return
+	}
+
+	tlsConfig = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+
+	return
+}
+
+// client tls config of mongodb connections
+// the CA file replaces the system roots if set
+func loadMongoTLSConfig(caFile string, insecure bool) (tlsConfig *tls.Config, err error) {
+
+	tlsConfig = &tls.Config{InsecureSkipVerify: insecure}
+	if caFile == "" {
+		return
+	}
+
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return
+	}
+	tlsConfig.RootCAs = x509.NewCertPool()
+	if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+		err = fmt.Errorf("no certificates found in %s", caFile)
+	}
+
+	return
+}
+
+// redirect plain http requests to the https listener
+func (s *server) redirectHandler(w http.ResponseWriter, r *http.Request) {
+
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+
+	// keep a non-default port of the https listener
+	if port := httpsPort(s.conf(), host); port != "" && port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+}
+
+// port of the https listener for requests to host
+// a listener bound to host wins over the first tcp one, unix sockets are skipped
+// no port is returned with only unix sockets, as a proxy in front of them serves 443
+func httpsPort(conf config, host string) (port string) {
+
+	for _, address := range listenAddresses(conf) {
+		if strings.HasPrefix(address, "unix:") {
+			continue
+		}
+		listen_host, listen_port, err := net.SplitHostPort(address)
+		if err != nil {
+			continue
+		}
+		if listen_host == host {
+			return listen_port
+		}
+		if port == "" {
+			port = listen_port
+		}
+	}
+
+	return
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectToListenerPort(t *testing.T) {
+
+	for _, test := range []struct {
+		listen    string
+		listeners []string
+		want      string
+	}{
+		{":8443", nil, "https://example.com:8443/gridfs/a.png?w=10"},
+		{":443", nil, "https://example.com/gridfs/a.png?w=10"},
+		{":8443", []string{"unix:/run/gogridfs.sock", ":9443"}, "https://example.com:9443/gridfs/a.png?w=10"},
+		{":8443", []string{"10.0.0.1:7443", "example.com:6443"}, "https://example.com:6443/gridfs/a.png?w=10"},
+		{":8443", []string{"unix:/run/gogridfs.sock"}, "https://example.com/gridfs/a.png?w=10"},
+	} {
+		conf := testConfig()
+		conf.Listen, conf.Listeners = test.listen, test.listeners
+		s, _ := newTestServer(t, conf)
+
+		w := httptest.NewRecorder()
+		s.redirectHandler(w, httptest.NewRequest("GET", "http://example.com:8080/gridfs/a.png?w=10", nil))
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != test.want {
+			t.Errorf("%s %v: got %d %s", test.listen, test.listeners, w.Code, w.Header().Get("Location"))
+		}
+	}
+}
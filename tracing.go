@@ -0,0 +1,290 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// span kinds of OTLP
+const (
+	spanKindServer = 2
+	spanKindClient = 3
+)
+
+// spans buffered between exports, further ones are dropped
+const maxPendingSpans = 4096
+
+// request and gridfs spans exported in the OTLP/HTTP JSON format
+// all methods are no-ops on a nil *tracer, which is the case when disabled
+type tracer struct {
+	endpoint string
+	client   *http.Client
+	lock     sync.Mutex
+	pending  []otlpSpan
+	dropped  uint64
+}
+
+// span in progress
+// all methods are no-ops on a nil *span
+type span struct {
+	tracer   *tracer
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+	attrs    map[string]interface{}
+}
+
+// context key of the current span
+type spanKey struct{}
+
+func newTracer(endpoint string) *tracer {
+	return &tracer{endpoint: endpoint, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// start a span below the one of the context, or a new trace without one
+func (t *tracer) start(ctx context.Context, name string, kind int) (context.Context, *span) {
+
+	if t == nil {
+		return ctx, nil
+	}
+
+	sp := &span{tracer: t, name: name, kind: kind, start: time.Now(), attrs: map[string]interface{}{}}
+	if parent, ok := ctx.Value(spanKey{}).(*span); ok {
+		sp.traceID = parent.traceID
+		sp.parentID = parent.spanID
+	} else {
+		rand.Read(sp.traceID[:])
+	}
+	rand.Read(sp.spanID[:])
+
+	return context.WithValue(ctx, spanKey{}, sp), sp
+}
+
+// start the server span of a request
+// a valid W3C traceparent header makes it part of the caller's trace
+func (t *tracer) startRequest(r *http.Request, name string) (*http.Request, *span) {
+
+	if t == nil {
+		return r, nil
+	}
+
+	ctx := r.Context()
+	if traceID, parentID, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+		ctx = context.WithValue(ctx, spanKey{}, &span{traceID: traceID, spanID: parentID})
+	}
+	ctx, sp := t.start(ctx, name, spanKindServer)
+	sp.set("http.request.method", r.Method)
+	sp.set("url.path", r.URL.Path)
+
+	return r.WithContext(ctx), sp
+}
+
+// trace and parent span id of a traceparent header like
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func parseTraceparent(header string) (traceID [16]byte, parentID [8]byte, ok bool) {
+
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return
+	}
+	if _, err := hex.Decode(traceID[:], []byte(parts[1])); err != nil {
+		return
+	}
+	if _, err := hex.Decode(parentID[:], []byte(parts[2])); err != nil {
+		return
+	}
+
+	// all zero ids are invalid
+	ok = traceID != [16]byte{} && parentID != [8]byte{}
+
+	return
+}
+
+// set an attribute of the span, strings, ints, int64s and bools are exported
+func (sp *span) set(key string, value interface{}) {
+	if sp == nil {
+		return
+	}
+	sp.attrs[key] = value
+}
+
+// finish the span and queue it for export, errors mark it as failed
+func (sp *span) end(err error) {
+
+	if sp == nil {
+		return
+	}
+
+	exported := otlpSpan{
+		TraceID:           hex.EncodeToString(sp.traceID[:]),
+		SpanID:            hex.EncodeToString(sp.spanID[:]),
+		Name:              sp.name,
+		Kind:              sp.kind,
+		StartTimeUnixNano: strconv.FormatInt(sp.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(time.Now().UnixNano(), 10),
+		Attributes:        otlpAttributes(sp.attrs),
+	}
+	if sp.parentID != [8]byte{} {
+		exported.ParentSpanID = hex.EncodeToString(sp.parentID[:])
+	}
+	if err != nil {
+		exported.Status = otlpStatus{Code: 2, Message: err.Error()}
+	}
+
+	t := sp.tracer
+	t.lock.Lock()
+	if len(t.pending) < maxPendingSpans {
+		t.pending = append(t.pending, exported)
+	} else {
+		t.dropped++
+	}
+	t.lock.Unlock()
+}
+
+// finish the server span of a request with the status written to w
+// server errors mark it as failed
+func (sp *span) endRequest(w http.ResponseWriter) {
+
+	if sp == nil {
+		return
+	}
+
+	status := http.StatusOK
+	var err error
+	if lw := loggingWriter(w); lw != nil {
+		if lw.status != 0 {
+			status = lw.status
+		} else if lw.canceled {
+			status = statusClientClosed
+		}
+		err = lw.err
+	}
+	sp.set("http.response.status_code", status)
+	if err == nil && status >= 500 {
+		err = errors.New(http.StatusText(status))
+	}
+
+	sp.end(err)
+}
+
+// OTLP/HTTP JSON encoding of spans
+type otlpSpan struct {
+	TraceID           string                   `json:"traceId"`
+	SpanID            string                   `json:"spanId"`
+	ParentSpanID      string                   `json:"parentSpanId,omitempty"`
+	Name              string                   `json:"name"`
+	Kind              int                      `json:"kind"`
+	StartTimeUnixNano string                   `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string                   `json:"endTimeUnixNano"`
+	Attributes        []map[string]interface{} `json:"attributes,omitempty"`
+	Status            otlpStatus               `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"` // 2 is error
+	Message string `json:"message,omitempty"`
+}
+
+// attributes as OTLP key values, sorted by key
+// ints are encoded as strings as OTLP JSON requires for 64 bit values
+func otlpAttributes(attrs map[string]interface{}) (encoded []map[string]interface{}) {
+
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var value map[string]interface{}
+		switch v := attrs[key].(type) {
+		case string:
+			value = map[string]interface{}{"stringValue": v}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		encoded = append(encoded, map[string]interface{}{"key": key, "value": value})
+	}
+
+	return
+}
+
+// send the finished spans to the OTLP endpoint
+func (t *tracer) flush() (err error) {
+
+	if t == nil {
+		return
+	}
+
+	t.lock.Lock()
+	spans, dropped := t.pending, t.dropped
+	t.pending, t.dropped = nil, 0
+	t.lock.Unlock()
+
+	if dropped > 0 {
+		defer func() {
+			if err == nil {
+				err = fmt.Errorf("tracing: dropped %d spans, the export can't keep up", dropped)
+			}
+		}()
+	}
+	if len(spans) == 0 {
+		return
+	}
+
+	resource := map[string]interface{}{"attributes": otlpAttributes(map[string]interface{}{
+		"service.name":    "gogridfs",
+		"service.version": version,
+	})}
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource":   resource,
+			"scopeSpans": []interface{}{map[string]interface{}{"scope": map[string]string{"name": "gogridfs"}, "spans": spans}},
+		}},
+	})
+	if err != nil {
+		return
+	}
+
+	resp, err := t.client.Post(t.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("tracing: export of %d spans failed: %w", len(spans), err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("tracing: export of %d spans failed: %s", len(spans), resp.Status)
+	}
+
+	return
+}
+
+// periodically export spans
+func (t *tracer) flushLoop(logger *log.Logger, interval time.Duration) {
+
+	for range time.Tick(interval) {
+		if err := t.flush(); err != nil {
+			logger.Println(err)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// default number of sniffed content types kept and their lifetime in seconds
+const (
+	defaultTypeCacheSize = 1024
+	defaultTypeCacheTTL  = 300
+)
+
+// sniffed content type of a file
+// the md5 guards against files replaced under the same _id
+type typeEntry struct {
+	key     string
+	ctype   string
+	md5     string
+	expires time.Time
+}
+
+// least recently used cache of sniffed content types, so hot files without a
+// stored type aren't read for sniffing on every request
+// all methods are no-ops on a nil *typeCache, which is the case when disabled
+type typeCache struct {
+	lock    sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List // most recently used first
+	entries map[string]*list.Element
+}
+
+func newTypeCache(size int, ttl time.Duration) *typeCache {
+	return &typeCache{size: size, ttl: ttl, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// cache key of a file of the mount
+func (m *mount) typeKey(id interface{}) string {
+	return fmt.Sprintf("%s\x00%s\x00%v", m.Database, m.GridFSCollection, id)
+}
+
+// cached content type for the key, if it's fresh and the md5 still matches
+func (c *typeCache) get(key string, md5 string) (ctype string, ok bool) {
+
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	element, found := c.entries[key]
+	if !found {
+		return
+	}
+	entry := element.Value.(*typeEntry)
+	if entry.md5 != md5 || time.Now().After(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return
+	}
+	c.order.MoveToFront(element)
+
+	return entry.ctype, true
+}
+
+// store the content type for the key, dropping the least recently used one if full
+func (c *typeCache) put(key string, md5 string, ctype string) {
+
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, found := c.entries[key]; found {
+		c.order.Remove(element)
+		delete(c.entries, key)
+	}
+	c.entries[key] = c.order.PushFront(&typeEntry{key: key, ctype: ctype, md5: md5, expires: time.Now().Add(c.ttl)})
+
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*typeEntry).key)
+	}
+}
+
+// forget the content type for the key, e.g. of an uploaded or deleted file
+func (c *typeCache) remove(key string) {
+
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if element, found := c.entries[key]; found {
+		c.order.Remove(element)
+		delete(c.entries, key)
+	}
+}
+
+// content type of a gridfile of the mount
+// sniffed types are cached, declared ones are known without reading the file
+func (m *mount) contentType(gfsFile gridFile) (ctype string, err error) {
+
+	ctype = declaredType(gfsFile)
+	if ctype != "" || m.srv.Types == nil {
+		return contentType(gfsFile)
+	}
+
+	key := m.typeKey(gfsFile.Id())
+	if ctype, ok := m.srv.Types.get(key, gfsFile.MD5()); ok {
+		m.srv.Metrics.typeCacheHit()
+		return ctype, nil
+	}
+	m.srv.Metrics.typeCacheMiss()
+
+	ctype, err = contentType(gfsFile)
+	if err == nil {
+		m.srv.Types.put(key, gfsFile.MD5(), ctype)
+	}
+
+	return
+}
+
+// content type of a file stored in the encoding
+// sniffing compressed content tells nothing about the original type
+func (m *mount) storedType(gfsFile gridFile, encoding string) (ctype string, err error) {
+
+	if encoding != "" && declaredType(gfsFile) == "" {
+		return "application/octet-stream", nil
+	}
+
+	return m.contentType(gfsFile)
+}
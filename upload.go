@@ -0,0 +1,420 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+)
+
+// uploads to an _id that is stored already
+var errIdExists = errors.New("a file with this _id exists")
+
+// store the content of the reader as a gridfs file with the given metadata
+// with the _id or a metadata field the value is stored there as well as in the filename
+func uploadFile(gfs gridStore, value string, field string, content io.Reader, ctype string, meta bson.M) (id interface{}, err error) {
+
+	// a failed or aborted write removes all chunks of its _id, which would be those
+	// of the stored file with a reused one, so existing ids are refused up front
+	// the check isn't atomic with the write, concurrent uploads of a new _id may both pass
+	if field == "_id" {
+		existing, err := openId(gfs, value)
+		if err == nil {
+			existing.Close()
+			return nil, errIdExists
+		} else if err != mgo.ErrNotFound {
+			return nil, err
+		}
+	}
+
+	gfsFile, err := gfs.Create(value)
+	if err != nil {
+		return
+	}
+
+	if field == "_id" {
+		gfsFile.SetId(value)
+	} else if isMetaField(field) {
+		if meta == nil {
+			meta = bson.M{}
+		}
+		meta[field[len("metadata."):]] = value
+	}
+	if len(meta) > 0 {
+		gfsFile.SetMeta(meta)
+	}
+	if ctype != "" {
+		gfsFile.SetContentType(ctype)
+	}
+
+	// drop the file on failed writes instead of leaving a partial one behind
+	_, err = io.Copy(gfsFile, content)
+	if err != nil {
+		gfsFile.Abort()
+		gfsFile.Close()
+		return
+	}
+
+	err = gfsFile.Close()
+	if err != nil {
+		return
+	}
+
+	id = gfsFile.Id()
+
+	return
+}
+
+// remove the other files sharing a filename with the one kept
+// called after the new file is stored, so one version is always visible
+func removeOthers(gfs gridStore, name string, keep interface{}) (err error) {
+
+	var doc struct {
+		Id interface{} `bson:"_id"`
+	}
+	iter := gfs.Find(bson.M{"filename": name, "_id": bson.M{"$ne": keep}}).Select(bson.M{"_id": 1}).Iter()
+	for iter.Next(&doc) {
+		if err = gfs.RemoveId(doc.Id); err != nil {
+			iter.Close()
+			return
+		}
+	}
+
+	return iter.Close()
+}
+
+// default limit of uploaded files
+const defaultMaxUploadBytes = 64 << 20
+
+// bound the body of an upload to maxuploadbytes, a negative limit means unlimited
+// answers too large ones with 413 and returns false
+func (m *mount) limitUpload(w http.ResponseWriter, r *http.Request, conf config) bool {
+
+	limit := conf.MaxUploadBytes
+	if limit == 0 {
+		limit = defaultMaxUploadBytes
+	}
+	if limit > 0 {
+		if r.ContentLength > limit {
+			m.srv.writeError(w, r, "payload too large", http.StatusRequestEntityTooLarge)
+			return false
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+
+	return true
+}
+
+// handle PUT requests for the mount
+func (m *mount) uploadHandler(w http.ResponseWriter, r *http.Request, path string) {
+
+	conf := m.srv.conf()
+
+	if !m.limitUpload(w, r, conf) {
+		return
+	}
+
+	// conditional uploads look up the current file first
+	// the check isn't atomic with the upload, concurrent ones may both pass
+	if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Match") != "" {
+		ctx, cancel := m.srv.mongoContext(r)
+		exists, etag := false, ""
+		gfsFile, err := m.srv.getFile(ctx, m.WriteGFS, path, m.Field, -1)
+		cancel()
+		if err == nil {
+			exists, etag = true, fileETag(gfsFile.MD5())
+			gfsFile.Close()
+		} else if err != mgo.ErrNotFound {
+			m.srv.logError(w, err)
+			m.srv.writeError(w, r, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		if !uploadPrecondition(r.Header, exists, etag) {
+			m.srv.writeError(w, r, "precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+		// files stored by _id have no versions, a passing If-Match can't replace them
+		if exists && m.Field == "_id" {
+			m.srv.writeError(w, r, errIdExists.Error()+", delete it first", http.StatusConflict)
+			return
+		}
+	}
+
+	// files exceeding the limit are aborted and removed by uploadFile
+	ctype := r.Header.Get("Content-Type")
+	meta := uploadMeta(r)
+	id, err := uploadFile(m.WriteGFS, path, m.Field, r.Body, ctype, meta)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		m.srv.writeError(w, r, "payload too large", http.StatusRequestEntityTooLarge)
+		return
+	} else if err == errIdExists {
+		m.srv.writeError(w, r, err.Error()+", delete it first", http.StatusConflict)
+		return
+	} else if err != nil {
+		m.srv.mongoError()
+		m.srv.logError(w, err)
+		m.srv.writeError(w, r, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// the _id may be reused for the new content
+	m.srv.Types.remove(m.typeKey(id))
+
+	// the upload succeeded even if older versions can't be removed
+	if conf.OverwriteReplace {
+		if err := removeOthers(m.WriteGFS, path, id); err != nil {
+			m.srv.mongoError()
+			m.srv.logError(w, fmt.Errorf("removing older versions of %s: %s", path, err))
+		}
+	}
+
+	m.srv.debug("uploaded", path)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"_id": id, "contentType": ctype, "metadata": meta})
+}
+
+// handle POST requests of html forms for the mount
+// every file of the multipart/form-data body is stored below dir with its filename
+// and content type, maxuploadbytes applies to the whole body
+// answered with a JSON array of the stored ids
+func (m *mount) formUploadHandler(w http.ResponseWriter, r *http.Request, dir string) {
+
+	conf := m.srv.conf()
+
+	if !m.limitUpload(w, r, conf) {
+		return
+	}
+
+	reader, err := r.MultipartReader()
+	if err == http.ErrNotMultipart {
+		m.srv.writeError(w, r, "multipart/form-data expected", http.StatusUnsupportedMediaType)
+		return
+	} else if err != nil {
+		m.srv.writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if dir != "" && !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+
+	// files of a failed request are removed again, so the form can be sent once more
+	ids := []interface{}{}
+	var paths []string
+	stored := false
+	defer func() {
+		if stored {
+			return
+		}
+		for _, id := range ids {
+			if err := m.WriteGFS.RemoveId(id); err != nil {
+				m.srv.logError(w, fmt.Errorf("removing %v of a failed upload: %s", id, err))
+			}
+		}
+	}()
+
+	var tooLarge *http.MaxBytesError
+	for {
+		// a limit hit within part headers surfaces as a malformed header,
+		// the body reader keeps returning the limit error then
+		part, err := reader.NextPart()
+		if err != nil && err != io.EOF && !errors.As(err, &tooLarge) {
+			if _, rerr := r.Body.Read(nil); errors.As(rerr, &tooLarge) {
+				err = rerr
+			}
+		}
+		if err == io.EOF {
+			break
+		} else if errors.As(err, &tooLarge) {
+			m.srv.writeError(w, r, "payload too large", http.StatusRequestEntityTooLarge)
+			return
+		} else if err != nil {
+			m.srv.writeError(w, r, "invalid multipart body", http.StatusBadRequest)
+			return
+		}
+
+		// other form fields are skipped
+		name := part.FileName()
+		if name == "" {
+			part.Close()
+			continue
+		}
+		name, err = cleanPath(name)
+		if err != nil || name == "" {
+			m.srv.writeError(w, r, "invalid filename", http.StatusBadRequest)
+			return
+		}
+
+		path := dir + name
+		id, err := uploadFile(m.WriteGFS, path, m.Field, part, part.Header.Get("Content-Type"), nil)
+		if errors.As(err, &tooLarge) {
+			m.srv.writeError(w, r, "payload too large", http.StatusRequestEntityTooLarge)
+			return
+		} else if err == errIdExists {
+			m.srv.writeError(w, r, path+": "+err.Error()+", delete it first", http.StatusConflict)
+			return
+		} else if err != nil {
+			m.srv.mongoError()
+			m.srv.logError(w, err)
+			m.srv.writeError(w, r, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		ids = append(ids, id)
+		paths = append(paths, path)
+		m.srv.Types.remove(m.typeKey(id))
+
+		m.srv.debug("uploaded", path)
+	}
+	stored = true
+
+	// older versions are only removed once the whole form is stored,
+	// so a failed request leaves every file as it was
+	// of files sent twice the last one is kept
+	if conf.OverwriteReplace {
+		replaced := map[string]bool{}
+		for i := len(ids) - 1; i >= 0; i-- {
+			if replaced[paths[i]] {
+				continue
+			}
+			replaced[paths[i]] = true
+			if err := removeOthers(m.WriteGFS, paths[i], ids[i]); err != nil {
+				m.srv.mongoError()
+				m.srv.logError(w, fmt.Errorf("removing older versions of %s: %s", paths[i], err))
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ids)
+}
+
+// metadata of an upload from its X-Meta-* headers
+// X-Meta-Color-Space: srgb is stored as {"color-space": "srgb"}
+func uploadMeta(r *http.Request) (meta bson.M) {
+
+	for name, values := range r.Header {
+		key := strings.ToLower(strings.TrimPrefix(name, "X-Meta-"))
+		if key == strings.ToLower(name) || key == "" {
+			continue
+		}
+		if meta == nil {
+			meta = bson.M{}
+		}
+		meta[key] = values[0]
+	}
+
+	return
+}
+
+// upload a local file into a mount and print its _id
+// the mount is given by its handlepath, the first one by default, and the name is like in
+// requests to it, defaulting to the base name of the file, the content type to the one of its extension
+// returns the exit code, 0 if the file was stored
+func uploadCommand(file string, local string, mountPath string, name string, ctype string) int {
+
+	conf, err := loadConfig(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	mc, err := commandMount(conf, mountPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if name == "" {
+		name = filepath.Base(local)
+	}
+	path, err := cleanPath(name)
+	if err != nil || path == "" {
+		fmt.Fprintln(os.Stderr, "invalid name", name)
+		return 1
+	}
+	if ctype == "" {
+		ctype = mime.TypeByExtension(filepath.Ext(local))
+	}
+
+	content, err := os.Open(local)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer content.Close()
+
+	logger := log.New(os.Stderr, "", 5)
+	mgo_session, err := dialMongo(logger, conf)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "unable to connect to mongodb:", err)
+		return 1
+	}
+	defer mgo_session.Close()
+
+	// the mount's own connection and the primary with strongwrites, like uploads over http
+	stores := newMongoStores(conf, mgo_session, logger)
+	defer stores.close()
+	_, gfs, err := stores.open(mc)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "unable to connect to mongodb:", err)
+		return 1
+	}
+
+	m := &mount{mountConfig: mc}
+	id, err := uploadFile(gfs, m.filename(path), m.Field, content, ctype, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "unable to upload", local+":", err)
+		return 1
+	}
+	fmt.Println(idString(id))
+
+	return 0
+}
+
+// config of the mount with the given handlepath, the first one for an empty path
+func commandMount(conf config, handlePath string) (mc mountConfig, err error) {
+
+	mounts := mountConfigs(conf)
+	mc = mounts[0]
+	if handlePath != "" {
+		found := false
+		for _, candidate := range mounts {
+			if candidate.HandlePath == withSlash(handlePath) {
+				mc, found = candidate, true
+			}
+		}
+		if !found {
+			err = fmt.Errorf("unknown mount %s", handlePath)
+			return
+		}
+	}
+
+	if prefix, trimmed := bucketName(mc.GridFSCollection); trimmed {
+		fmt.Fprintln(os.Stderr, "gridfscollection", mc.GridFSCollection, "names a collection of the bucket, using", prefix)
+		mc.GridFSCollection = prefix
+	}
+
+	return
+}
+
+// _id as printed for operators, ObjectIds as their hex digits
+func idString(id interface{}) string {
+
+	if oid, ok := id.(bson.ObjectId); ok {
+		return oid.Hex()
+	}
+
+	return fmt.Sprint(id)
+}
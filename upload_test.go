@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"labix.org/v2/mgo/bson"
+)
+
+// config of a mount accepting uploads
+func uploadConfig(field string) config {
+	conf := testConfig()
+	conf.Field = field
+	conf.AllowUpload = true
+	return conf
+}
+
+// PUT a file to the server
+func put(s *server, path string, content string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("PUT", path, strings.NewReader(content))
+	r.Header.Set("Content-Type", "text/plain")
+	return serve(s, r)
+}
+
+func TestUploadFile(t *testing.T) {
+
+	s, gfs := newTestServer(t, uploadConfig("filename"))
+
+	w := put(s, "/gridfs/docs/a.txt", "hello")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+	if names := gfs.names(); len(names) != 1 || names[0] != "docs/a.txt" {
+		t.Fatalf("stored %v", names)
+	}
+
+	w = serve(s, httptest.NewRequest("GET", "/gridfs/docs/a.txt", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "hello" || w.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("got %d %q %s", w.Code, w.Body.String(), w.Header().Get("Content-Type"))
+	}
+}
+
+func TestUploadExistingId(t *testing.T) {
+
+	s, gfs := newTestServer(t, uploadConfig("_id"))
+
+	if w := put(s, "/gridfs/logo", "first"); w.Code != http.StatusCreated {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+
+	// the stored file must survive uploads to its _id
+	w := put(s, "/gridfs/logo", "second")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+	stored := gfs.get("logo")
+	if stored == nil || string(stored.content) != "first" {
+		t.Fatalf("stored file changed: %v", stored)
+	}
+}
+
+func TestConditionalUpload(t *testing.T) {
+
+	tests := []struct {
+		field  string
+		header string
+		value  string
+		status int
+	}{
+		{"filename", "If-None-Match", "*", http.StatusPreconditionFailed},
+		{"filename", "If-Match", "*", http.StatusCreated},
+		{"filename", "If-Match", `"other"`, http.StatusPreconditionFailed},
+		{"_id", "If-None-Match", "*", http.StatusPreconditionFailed},
+		{"_id", "If-Match", "*", http.StatusConflict},
+	}
+	for _, test := range tests {
+		s, gfs := newTestServer(t, uploadConfig(test.field))
+		if w := put(s, "/gridfs/a.txt", "first"); w.Code != http.StatusCreated {
+			t.Fatalf("got %d %s", w.Code, w.Body.String())
+		}
+
+		r := httptest.NewRequest("PUT", "/gridfs/a.txt", strings.NewReader("second"))
+		r.Header.Set(test.header, test.value)
+		w := serve(s, r)
+		if w.Code != test.status {
+			t.Errorf("%s %s: %s: got %d, want %d", test.field, test.header, test.value, w.Code, test.status)
+		}
+		if files := len(gfs.names()); test.status != http.StatusCreated && files != 1 {
+			t.Errorf("%s %s: %s: %d files stored", test.field, test.header, test.value, files)
+		}
+		if test.field == "_id" && string(gfs.get("a.txt").content) != "first" {
+			t.Errorf("%s %s: %s: stored file changed", test.field, test.header, test.value)
+		}
+	}
+
+	// missing files only pass If-None-Match
+	s, _ := newTestServer(t, uploadConfig("filename"))
+	r := httptest.NewRequest("PUT", "/gridfs/b.txt", strings.NewReader("new"))
+	r.Header.Set("If-Match", "*")
+	if w := serve(s, r); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("If-Match on a missing file: got %d", w.Code)
+	}
+}
+
+// multipart/form-data body with a file part per name and content
+func formBody(t *testing.T, files ...string) (body *bytes.Buffer, ctype string) {
+
+	body = &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	form.WriteField("submit", "upload")
+	for i := 0; i+1 < len(files); i += 2 {
+		part, err := form.CreateFormFile("file", files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte(files[i+1]))
+	}
+	form.Close()
+
+	return body, form.FormDataContentType()
+}
+
+func TestConditionalUploadLookupError(t *testing.T) {
+
+	conf := uploadConfig("filename")
+	conf.RetryBackoff = 1
+	s, gfs := newTestServer(t, conf)
+	gfs.openErr = errors.New("no reachable servers")
+
+	r := httptest.NewRequest("PUT", "/gridfs/a.txt", strings.NewReader("a"))
+	r.Header.Set("If-None-Match", "*")
+	if w := serve(s, r); w.Code != http.StatusInternalServerError {
+		t.Errorf("got %d", w.Code)
+	}
+	// the lookup and its retry, each counted once
+	if failed := s.Runtime.mongoErrors.Load(); failed != 2 {
+		t.Errorf("counted %d mongodb errors", failed)
+	}
+}
+
+func TestFormUpload(t *testing.T) {
+
+	s, gfs := newTestServer(t, uploadConfig("filename"))
+
+	body, ctype := formBody(t, "a.txt", "aaa", "b.txt", "bbb")
+	r := httptest.NewRequest("POST", "/gridfs/docs/", body)
+	r.Header.Set("Content-Type", ctype)
+	w := serve(s, r)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+	var ids []string
+	if err := json.Unmarshal(w.Body.Bytes(), &ids); err != nil || len(ids) != 2 {
+		t.Fatalf("got %s", w.Body.String())
+	}
+	if names := gfs.names(); strings.Join(names, ",") != "docs/a.txt,docs/b.txt" {
+		t.Errorf("stored %v", names)
+	}
+
+	r = httptest.NewRequest("POST", "/gridfs/docs/", strings.NewReader("plain"))
+	r.Header.Set("Content-Type", "text/plain")
+	if w := serve(s, r); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("plain body: got %d", w.Code)
+	}
+}
+
+func TestFailedFormUploadKeepsVersions(t *testing.T) {
+
+	conf := uploadConfig("filename")
+	conf.OverwriteReplace = true
+	conf.MaxUploadBytes = 1000
+	s, gfs := newTestServer(t, conf)
+	old := gfs.put("a.txt", "old", "text/plain", nil)
+
+	// the second file exceeds the limit after the first one is stored
+	body, ctype := formBody(t, "a.txt", "new", "b.txt", strings.Repeat("b", 2000))
+	r := httptest.NewRequest("POST", "/gridfs/", body)
+	r.Header.Set("Content-Type", ctype)
+	r.ContentLength = -1
+	if w := serve(s, r); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+	if names := gfs.names(); len(names) != 1 || gfs.get(old.id) == nil {
+		t.Fatalf("stored %v, want the old a.txt only", names)
+	}
+
+	// stored forms replace older versions, of files sent twice the last one is kept
+	body, ctype = formBody(t, "a.txt", "first", "a.txt", "second")
+	r = httptest.NewRequest("POST", "/gridfs/", body)
+	r.Header.Set("Content-Type", ctype)
+	if w := serve(s, r); w.Code != http.StatusCreated {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+	if len(gfs.files) != 1 || string(gfs.files[0].content) != "second" {
+		t.Errorf("stored %v", gfs.names())
+	}
+}
+
+func TestUploadLimit(t *testing.T) {
+
+	conf := uploadConfig("filename")
+	conf.MaxUploadBytes = 5
+	s, gfs := newTestServer(t, conf)
+
+	if w := put(s, "/gridfs/under.txt", "12345"); w.Code != http.StatusCreated {
+		t.Errorf("at the limit: got %d %s", w.Code, w.Body.String())
+	}
+	if w := put(s, "/gridfs/over.txt", "123456"); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("over the limit: got %d %s", w.Code, w.Body.String())
+	}
+
+	// chunked bodies are cut off while they are stored
+	r := httptest.NewRequest("PUT", "/gridfs/chunked.txt", strings.NewReader("123456"))
+	r.ContentLength = -1
+	if w := serve(s, r); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("chunked over the limit: got %d %s", w.Code, w.Body.String())
+	}
+
+	if names := gfs.names(); len(names) != 1 || names[0] != "under.txt" {
+		t.Errorf("stored %v", names)
+	}
+}
+
+func TestUploadCommandMount(t *testing.T) {
+
+	conf := testConfig()
+	conf.PathPrefix = "public/"
+	conf.Mounts = []mountConfig{
+		{HandlePath: "/gridfs"},
+		{HandlePath: "/images/", GridFSCollection: "images.files", PathPrefix: "img/"},
+	}
+
+	mc, err := commandMount(conf, "")
+	if err != nil || mc.HandlePath != "/gridfs/" || mc.PathPrefix != "public/" {
+		t.Errorf("default: got %+v %v", mc, err)
+	}
+	mc, err = commandMount(conf, "/images")
+	if err != nil || mc.GridFSCollection != "images" {
+		t.Errorf("/images: got %+v %v", mc, err)
+	}
+	if m := (&mount{mountConfig: mc}); m.filename("logo.png") != "img/logo.png" {
+		t.Errorf("stored as %s", m.filename("logo.png"))
+	}
+	if _, err := commandMount(conf, "/missing/"); err == nil {
+		t.Error("unknown mount accepted")
+	}
+}
+
+func TestIdString(t *testing.T) {
+
+	id := bson.NewObjectId()
+	if got := idString(id); got != id.Hex() {
+		t.Errorf("got %s, want %s", got, id.Hex())
+	}
+	if got := idString("logo"); got != "logo" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestOverwriteReplace(t *testing.T) {
+
+	for _, replace := range []bool{false, true} {
+		conf := uploadConfig("filename")
+		conf.OverwriteReplace = replace
+		s, gfs := newTestServer(t, conf)
+
+		put(s, "/gridfs/a.txt", "first")
+		if w := put(s, "/gridfs/a.txt", "second"); w.Code != http.StatusCreated {
+			t.Fatalf("got %d %s", w.Code, w.Body.String())
+		}
+
+		want := 2
+		if replace {
+			want = 1
+		}
+		if names := gfs.names(); len(names) != want {
+			t.Errorf("overwritereplace %t: stored %v", replace, names)
+		}
+		if w := serve(s, httptest.NewRequest("GET", "/gridfs/a.txt", nil)); w.Body.String() != "second" {
+			t.Errorf("overwritereplace %t: serves %q", replace, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// check the config for missing or invalid fields
+// all problems are reported at once
+func validateConfig(conf config) error {
+
+	var problems []string
+
+	if len(conf.Servers) == 0 && conf.MongoURI == "" {
+		problems = append(problems, "servers: at least one mongodb server or a mongouri is required")
+	}
+
+	// every path can be registered once only
+	paths := map[string]bool{}
+	for _, path := range []string{conf.HealthPath, conf.MetricsPath, conf.VersionPath, conf.StatsPath, conf.RuntimePath} {
+		if path != "" {
+			paths[path] = true
+		}
+	}
+
+	mounts := mountConfigs(conf)
+	for i, m := range mounts {
+		// name problems of mounts by their position
+		prefix := ""
+		if len(conf.Mounts) > 0 {
+			prefix = fmt.Sprintf("mounts[%d].", i)
+		}
+
+		if m.Database == "" {
+			problems = append(problems, prefix+"database: must be set")
+		}
+		if m.HandlePath == "" {
+			problems = append(problems, prefix+"handlepath: must be set")
+		} else if !strings.HasPrefix(m.HandlePath, "/") {
+			problems = append(problems, prefix+`handlepath: must start with "/"`)
+		} else if paths[m.HandlePath] {
+			problems = append(problems, prefix+"handlepath: "+m.HandlePath+" is used twice")
+		}
+		paths[m.HandlePath] = true
+		if _, ok := mountModes[strings.ToLower(m.Mode)]; m.Mode != "" && !ok {
+			problems = append(problems, prefix+"mode: must be strong, monotonic or eventual")
+		}
+		if m.Field != "" && m.Field != "_id" && m.Field != "filename" && !isMetaField(m.Field) {
+			problems = append(problems, prefix+`field: must be "_id", "filename" or "metadata.<key>"`)
+		}
+		for _, named := range [][2]string{{"metapath", m.MetaPath}, {"listpath", m.ListPath}, {"zippath", m.ZipPath}} {
+			name, path := named[0], named[1]
+			if path == "" {
+				continue
+			}
+			if !strings.HasPrefix(path, "/") {
+				problems = append(problems, prefix+name+`: must start with "/"`)
+			} else if paths[path] {
+				problems = append(problems, prefix+name+": "+path+" is used twice")
+			}
+			paths[path] = true
+		}
+	}
+
+	// path patterns must be valid and not conflict with any other route
+	patterns := http.NewServeMux()
+	for path := range paths {
+		registerPattern(patterns, path)
+	}
+	for i, m := range mounts {
+		prefix := ""
+		if len(conf.Mounts) > 0 {
+			prefix = fmt.Sprintf("mounts[%d].", i)
+		}
+		for _, pattern := range m.PathPatterns {
+			if !strings.Contains(pattern, "{path}") && !strings.Contains(pattern, "{path...}") {
+				problems = append(problems, prefix+"pathpatterns: "+pattern+" must name the file with {path} or {path...}")
+			} else if err := registerPattern(patterns, pattern); err != nil {
+				problems = append(problems, prefix+"pathpatterns: "+err.Error())
+			}
+		}
+	}
+
+	if conf.HealthPath != "" && !strings.HasPrefix(conf.HealthPath, "/") {
+		problems = append(problems, `healthpath: must start with "/"`)
+	}
+	if conf.MetricsPath != "" && !strings.HasPrefix(conf.MetricsPath, "/") {
+		problems = append(problems, `metricspath: must start with "/"`)
+	}
+	if conf.VersionPath != "" && !strings.HasPrefix(conf.VersionPath, "/") {
+		problems = append(problems, `versionpath: must start with "/"`)
+	}
+	if conf.StatsPath != "" && !strings.HasPrefix(conf.StatsPath, "/") {
+		problems = append(problems, `statspath: must start with "/"`)
+	}
+	if conf.RuntimePath != "" && !strings.HasPrefix(conf.RuntimePath, "/") {
+		problems = append(problems, `runtimepath: must start with "/"`)
+	}
+	if _, ok := readPreferences[strings.ToLower(conf.ReadPreference)]; conf.ReadPreference != "" && !ok {
+		problems = append(problems, "readpreference: must be primary, primaryPreferred, secondary, secondaryPreferred or nearest")
+	}
+	if _, ok := logLevels[strings.ToLower(conf.LogLevel)]; conf.LogLevel != "" && !ok {
+		problems = append(problems, "loglevel: must be debug, info, warn or error")
+	}
+	for _, pattern := range conf.LogExcludePaths {
+		if _, err := path.Match(pattern, ""); err != nil {
+			problems = append(problems, "logexcludepaths: invalid pattern "+pattern)
+		}
+	}
+	if conf.LogSampleRate < 0 || conf.LogSampleRate > 1 {
+		problems = append(problems, "logsamplerate: must be between 0 and 1")
+	}
+	if _, ok := checksumHeaders[strings.ToLower(conf.ChecksumHeader)]; conf.ChecksumHeader != "" && !ok {
+		problems = append(problems, "checksumheader: must be digest or content-md5")
+	}
+	if _, err := parseProxies(conf.TrustedProxies); err != nil {
+		problems = append(problems, "trustedproxies: "+err.Error())
+	}
+	if conf.NotFoundStatus != 0 && (conf.NotFoundStatus < 200 || conf.NotFoundStatus > 599) {
+		problems = append(problems, "notfoundstatus: must be a status code between 200 and 599")
+	}
+	if conf.Profiling && conf.AdminListen == "" {
+		problems = append(problems, "adminlisten: must be set for profiling")
+	}
+	if conf.MongoUser == "" && (conf.MongoPassword != "" || conf.AuthDatabase != "") {
+		problems = append(problems, "mongouser: must be set with mongopassword or authdatabase")
+	}
+	if (conf.MongoCAFile != "" || conf.MongoInsecure) && !conf.MongoTLS {
+		problems = append(problems, "mongotls: must be set with mongocafile or mongoinsecure")
+	} else if conf.MongoCAFile != "" {
+		if _, err := loadMongoTLSConfig(conf.MongoCAFile, conf.MongoInsecure); err != nil {
+			problems = append(problems, "mongocafile: "+err.Error())
+		}
+	}
+	if (conf.TLSCert == "") != (conf.TLSKey == "") {
+		problems = append(problems, "tlscert, tlskey: both or none must be set")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("invalid config:\n\t" + strings.Join(problems, "\n\t"))
+	}
+
+	return nil
+}
+
+// register a route with the mux, reporting invalid and conflicting patterns
+// instead of panicking
+func registerPattern(mux *http.ServeMux, pattern string) (err error) {
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	mux.HandleFunc(pattern, http.NotFound)
+
+	return
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"hash"
+	"io"
+)
+
+// hash the bytes streamed to a writer to compare them with the stored md5
+type md5Writer struct {
+	io.Writer
+	hash hash.Hash
+}
+
+// wrap the writer so everything written to it is hashed as well
+func newMD5Writer(w io.Writer) *md5Writer {
+	hash := md5.New()
+	return &md5Writer{Writer: io.MultiWriter(w, hash), hash: hash}
+}
+
+// check the hashed bytes against the md5 stored in gridfs
+func (mw *md5Writer) verify(gfsFile gridFile) bool {
+	return hex.EncodeToString(mw.hash.Sum(nil)) == gfsFile.MD5()
+}
+
+// read the whole file and check it against the md5 stored in gridfs
+// without sending it anywhere
+func (s *server) verifyFile(ctx context.Context, gfsFile gridFile) (ok bool, err error) {
+
+	mw := newMD5Writer(io.Discard)
+	_, err = s.streamFile(ctx, mw, gfsFile, gfsFile.Size())
+	if err != nil {
+		return
+	}
+
+	return mw.verify(gfsFile), nil
+}
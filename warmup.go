@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"labix.org/v2/mgo"
+)
+
+// most files per warmup request
+const maxWarmupFiles = 1000
+
+// outcome of warming up one file
+type warmupResult struct {
+	File        string      `json:"file"`
+	Ok          bool        `json:"ok"`
+	Id          interface{} `json:"_id,omitempty"`
+	ETag        string      `json:"etag,omitempty"`
+	ContentType string      `json:"contentType,omitempty"`
+	Error       string      `json:"error,omitempty"`
+}
+
+// handle POST requests opening a list of files ahead of their first requests
+// the body is like {"mount": "/gridfs/", "files": [...], "sniff": true}, the first mount
+// is used without one, files are named like in requests to it
+// every file is opened, which only warms mongodb's own cache, lookups aren't cached here
+// with sniff the content types of files without a stored one are also put in the type cache,
+// sparing their first requests the read for sniffing
+// answered with the outcome of every file
+func (s *server) warmupHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != "POST" {
+		s.writeError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		Mount string   `json:"mount"`
+		Files []string `json:"files"`
+		Sniff bool     `json:"sniff"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.writeError(w, r, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if len(body.Files) == 0 || len(body.Files) > maxWarmupFiles {
+		s.writeError(w, r, fmt.Sprintf("between 1 and %d files must be given", maxWarmupFiles), http.StatusBadRequest)
+		return
+	}
+
+	m := s.Mounts[0]
+	if body.Mount != "" {
+		m = nil
+		for _, candidate := range s.Mounts {
+			if candidate.HandlePath == withSlash(body.Mount) {
+				m = candidate
+			}
+		}
+		if m == nil {
+			s.writeError(w, r, "unknown mount "+body.Mount, http.StatusBadRequest)
+			return
+		}
+	}
+
+	results := make([]warmupResult, 0, len(body.Files))
+	warmed := 0
+	for _, name := range body.Files {
+		if r.Context().Err() != nil {
+			return
+		}
+		result := warmupResult{File: name}
+
+		path, err := cleanPath(name)
+		if err != nil || path == "" {
+			result.Error = "invalid path"
+			results = append(results, result)
+			continue
+		}
+
+		// mongotimeout applies to every file on its own
+		ctx, cancel := s.mongoContext(r)
+		gfsFile, err := s.getFile(ctx, m.GFS, m.filename(path), m.Field, -1)
+		if err == mgo.ErrNotFound {
+			result.Error = "file not found"
+		} else if err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Id, result.ETag = gfsFile.Id(), fileETag(gfsFile.MD5())
+			if body.Sniff {
+				result.ContentType, err = m.contentType(gfsFile)
+			} else {
+				result.ContentType = declaredType(gfsFile)
+			}
+			gfsFile.Close()
+			if err != nil {
+				result.Error = err.Error()
+			}
+		}
+		cancel()
+		result.Ok = result.Error == ""
+		if result.Ok {
+			warmed++
+		}
+		results = append(results, result)
+	}
+
+	s.info(fmt.Sprintf("warmed up %d of %d files of %s", warmed, len(body.Files), m.HandlePath))
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(results)
+	if err != nil {
+		s.logError(w, err)
+	}
+}
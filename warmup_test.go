@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// results of a warmup request to the admin routes
+func warmup(t *testing.T, s *server, body string) (results []warmupResult) {
+
+	w := httptest.NewRecorder()
+	s.adminRoutes().ServeHTTP(w, httptest.NewRequest("POST", "/debug/warmup", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %s", w.Code, w.Body.String())
+	}
+	if err := json.NewDecoder(w.Body).Decode(&results); err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
+
+func TestWarmup(t *testing.T) {
+
+	s, gfs := newTestServer(t, testConfig())
+	file := gfs.put("logo", "\x89PNG\r\n\x1a\n", "", nil)
+
+	// without sniff only the declared type is known and nothing is cached
+	results := warmup(t, s, `{"files": ["logo", "missing"]}`)
+	if len(results) != 2 || !results[0].Ok || results[0].ETag != `"`+file.md5+`"` || results[0].ContentType != "" {
+		t.Fatalf("got %+v", results)
+	}
+	if results[1].Ok || results[1].Error != "file not found" {
+		t.Errorf("missing: got %+v", results[1])
+	}
+	m := s.Mounts[0]
+	if _, ok := s.Types.get(m.typeKey(file.id), file.md5); ok {
+		t.Error("type cached without sniff")
+	}
+
+	results = warmup(t, s, `{"files": ["logo"], "sniff": true, "mount": "/gridfs"}`)
+	if len(results) != 1 || results[0].ContentType != "image/png" {
+		t.Fatalf("got %+v", results)
+	}
+
+	// the first request is spared the sniffing read
+	gfs.read.Store(0)
+	r := httptest.NewRequest("GET", "/gridfs/logo", nil)
+	r.Header.Set("Range", "bytes=0-0")
+	w := serve(s, r)
+	if w.Header().Get("Content-Type") != "image/png" || gfs.read.Load() != 1 {
+		t.Errorf("got %s after reading %d bytes", w.Header().Get("Content-Type"), gfs.read.Load())
+	}
+}
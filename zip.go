@@ -0,0 +1,192 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"labix.org/v2/mgo"
+)
+
+// most files per archive
+const maxZipFiles = 100
+
+// handle requests for a zip archive of several files of the mount
+// files are named by file query parameters or a JSON body like {"files": [...]}
+// missing files are skipped and reported in the X-Missing-Files trailer and a missing.txt entry,
+// as are files stored in an encoding other than gzip
+func (m *mount) zipHandler(w http.ResponseWriter, r *http.Request) {
+
+	conf := m.srv.conf()
+
+	if !m.srv.checkRateLimit(w, r) {
+		return
+	}
+	if !m.srv.checkMaintenance(w, r, conf) {
+		return
+	}
+	if handleCORS(w, r, conf) {
+		return
+	}
+	if !m.srv.checkAuth(w, r, conf) {
+		return
+	}
+
+	m, err := m.withDatabase(r)
+	if err != nil {
+		m.srv.writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m, err = m.withField(r)
+	if err != nil {
+		m.srv.writeError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	names := r.URL.Query()["file"]
+	if r.Method == "POST" {
+		var body struct {
+			Files []string `json:"files"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			m.srv.writeError(w, r, "invalid JSON body", http.StatusBadRequest)
+			return
+		}
+		names = append(names, body.Files...)
+	}
+	if len(names) == 0 || len(names) > maxZipFiles {
+		m.srv.writeError(w, r, fmt.Sprintf("between 1 and %d files must be requested", maxZipFiles), http.StatusBadRequest)
+		return
+	}
+	for i, name := range names {
+		names[i], err = cleanPath(name)
+		if err != nil || names[i] == "" {
+			m.srv.writeError(w, r, "invalid path", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !m.srv.acquireSlot(w, r) {
+		return
+	}
+	defer m.srv.releaseSlot()
+
+	archive := r.URL.Query().Get("name")
+	if archive == "" {
+		archive = "files.zip"
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", contentDisposition("attachment", archive))
+	w.Header().Set("Trailer", "X-Missing-Files")
+
+	// headers are gone once the first entry is written, errors after it can only be logged
+	zw := zip.NewWriter(w)
+	var missing []string
+	started := false
+	for _, name := range names {
+		// the mongo timeout bounds each lookup, not the whole archive
+		ctx, cancel := m.srv.mongoContext(r)
+		gfsFile, err := m.srv.getFile(ctx, m.GFS, m.filename(name), m.Field, -1)
+		cancel()
+		if err == mgo.ErrNotFound {
+			missing = append(missing, name)
+			continue
+		} else if err != nil {
+			m.zipError(w, r, name, err, started)
+			return
+		}
+
+		// entries hold the original content, files stored gzipped are decompressed
+		// and those in encodings that can't be decoded are left out
+		encoding := ""
+		if gfsFile.Size() > 0 {
+			encoding = storedEncoding(gfsFile)
+		}
+		if encoding != "" && encoding != "gzip" {
+			gfsFile.Close()
+			missing = append(missing, name)
+			continue
+		}
+
+		// sniffed types count like for single files
+		ctype, err := m.storedType(gfsFile, encoding)
+		if err != nil {
+			gfsFile.Close()
+			m.zipError(w, r, name, err, started)
+			return
+		}
+		if !m.allowed(gfsFile.Name(), ctype) {
+			gfsFile.Close()
+			missing = append(missing, name)
+			continue
+		}
+
+		// compressed formats are stored as they are
+		method := zip.Store
+		if isCompressible(ctype) {
+			method = zip.Deflate
+		}
+		entry, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: method, Modified: gfsFile.UploadDate()})
+		started = true
+		if err == nil && encoding == "gzip" {
+			gunzip := newGunzipWriter(entry)
+			_, err = m.srv.streamFile(r.Context(), gunzip, gfsFile, gfsFile.Size())
+			if closeErr := gunzip.Close(); err == nil {
+				err = closeErr
+			}
+		} else if err == nil {
+			_, err = m.srv.streamFile(r.Context(), entry, gfsFile, gfsFile.Size())
+		}
+		gfsFile.Close()
+		if err != nil {
+			m.srv.logError(w, err)
+			return
+		}
+	}
+
+	if len(missing) > 0 {
+		entry, err := zw.Create("missing.txt")
+		if err == nil {
+			_, err = fmt.Fprintln(entry, strings.Join(missing, "\n"))
+		}
+		if err != nil {
+			m.srv.logError(w, err)
+			return
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		m.srv.logError(w, err)
+		return
+	}
+	w.Header().Set("X-Missing-Files", strings.Join(missing, ", "))
+}
+
+// answer a failed lookup of a file of the archive
+// before the first entry the archive headers are replaced by an error page,
+// 504 if the lookup timed out, afterwards the archive is cut off and the error logged
+func (m *mount) zipError(w http.ResponseWriter, r *http.Request, name string, err error, started bool) {
+
+	if r.Context().Err() == context.Canceled {
+		m.srv.logCanceled(w, r)
+		return
+	}
+
+	status := http.StatusInternalServerError
+	if err == context.DeadlineExceeded {
+		err = fmt.Errorf("lookup of %s timed out", name)
+		status = http.StatusGatewayTimeout
+	}
+	m.srv.logError(w, err)
+	if started {
+		return
+	}
+
+	for _, header := range []string{"Content-Type", "Content-Disposition", "Trailer"} {
+		w.Header().Del(header)
+	}
+	m.srv.writeError(w, r, strings.ToLower(http.StatusText(status)), status)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"labix.org/v2/mgo/bson"
+)
+
+// entries of a zip archive by name
+func zipEntries(t *testing.T, data []byte) map[string]string {
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := map[string]string{}
+	for _, entry := range archive.File {
+		f, err := entry.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", entry.Name, err)
+		}
+		entries[entry.Name] = string(content)
+	}
+
+	return entries
+}
+
+func TestZipDecodesStoredEncodings(t *testing.T) {
+
+	conf := testConfig()
+	conf.ZipPath = "/zip/"
+	s, gfs := newTestServer(t, conf)
+
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write([]byte("body { color: red }"))
+	gz.Close()
+	gfs.put("plain.txt", "plain", "text/plain", nil)
+	gfs.put("style.css", gzipped.String(), "text/css", bson.M{"gzip": true})
+	gfs.put("app.js", "\x1b\x03", "application/javascript", bson.M{"encoding": "br"})
+
+	w := serve(s, httptest.NewRequest("GET", "/zip/?file=plain.txt&file=style.css&file=app.js", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got %d %q", w.Code, w.Body.String())
+	}
+	entries := zipEntries(t, w.Body.Bytes())
+	if len(entries) != 3 || entries["plain.txt"] != "plain" || entries["style.css"] != "body { color: red }" || entries["missing.txt"] != "app.js\n" {
+		t.Errorf("got %q", entries)
+	}
+}
+
+func TestZipFailedFirstLookup(t *testing.T) {
+
+	conf := testConfig()
+	conf.ZipPath = "/zip/"
+	conf.RetryBackoff = 1
+	s, gfs := newTestServer(t, conf)
+	gfs.put("a.txt", "a", "text/plain", nil)
+
+	// nothing is written yet, so the client gets an error instead of an empty archive
+	for err, want := range map[error]int{
+		errors.New("no reachable servers"): http.StatusInternalServerError,
+		context.DeadlineExceeded:           http.StatusGatewayTimeout,
+	} {
+		gfs.openErr = err
+		w := serve(s, httptest.NewRequest("GET", "/zip/?file=a.txt", nil))
+		if w.Code != want || w.Header().Get("Content-Type") == "application/zip" || w.Header().Get("Content-Disposition") != "" {
+			t.Errorf("%v: got %d %s %q", err, w.Code, w.Header().Get("Content-Type"), w.Header().Get("Content-Disposition"))
+		}
+	}
+}